@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// adminTemplate /admin 页面的模板
+var adminTemplate = template.Must(template.New("admin.html").Funcs(template.FuncMap{
+	"mb":  megabytes,
+	"url": func(path string) string { return Storage.URL(path) },
+}).ParseFS(htmlFS, "html/admin.html"))
+
+// adminPage 渲染 /admin 页面使用的数据
+type adminPage struct {
+	GeneratedAt   time.Time
+	ImageCount    int64
+	ImageBytes    int64
+	StaticDir     string
+	DiskUsage     int64
+	DiskFree      int64
+	Months        []monthUsage
+	TopDownloads  []*imageStat
+	RecentUploads []*imageRecord
+}
+
+// monthUsage 某个月上传的图片数量和大小
+type monthUsage struct {
+	Month string
+	Count int64
+	Bytes int64
+}
+
+// adminPageAuth 与 adminAuth 相同，浏览器直接访问时通过 Basic 认证弹窗输入 ADMIN_KEY（用户名任意）
+func adminPageAuth() gin.HandlerFunc {
+	auth := adminAuth()
+	return func(context *gin.Context) {
+		context.Header("WWW-Authenticate", `Basic realm="go-drawing-bed", charset="UTF-8"`)
+		auth(context)
+		if !context.IsAborted() {
+			context.Writer.Header().Del("WWW-Authenticate")
+		}
+	}
+}
+
+// adminHandler 返回管理页面：图片数量和大小、按月统计、下载最多的 10 张图片和最近上传的 10 张图片，
+// 使用本地存储时还会统计 ./static 的磁盘占用和剩余空间。页面每 30 秒自动刷新
+func adminHandler(context *gin.Context) {
+	page := &adminPage{GeneratedAt: time.Now(), StaticDir: staticDir}
+	err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size_bytes), 0) FROM images`).Scan(&page.ImageCount, &page.ImageBytes)
+	if err == nil {
+		page.Months, err = monthlyUsage()
+	}
+	if err == nil {
+		page.TopDownloads, err = topStats(10)
+	}
+	if err == nil {
+		page.RecentUploads, _, err = listImages(1, 10, time.Time{}, nil)
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if local, ok := Storage.(*LocalBackend); ok {
+		page.DiskUsage = directorySize(local.Root)
+		if free, err := diskFree(local.Root); err == nil {
+			page.DiskFree = free
+		}
+	}
+
+	context.Header("Content-Type", "text/html; charset=utf-8")
+	if err := adminTemplate.Execute(context.Writer, page); err != nil {
+		slog.ErrorContext(context, "failed to render admin page", "error", err)
+	}
+}
+
+// monthlyUsage 按上传的年月统计图片数量和大小，最近的月份在前
+func monthlyUsage() ([]monthUsage, error) {
+	rows, err := db.Query(`SELECT strftime('%Y-%m', uploaded_at, 'unixepoch', 'localtime') AS month,
+		COUNT(*), SUM(size_bytes) FROM images GROUP BY month ORDER BY month DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	months := []monthUsage{}
+	for rows.Next() {
+		var month monthUsage
+		if err := rows.Scan(&month.Month, &month.Count, &month.Bytes); err != nil {
+			return nil, err
+		}
+		months = append(months, month)
+	}
+	return months, rows.Err()
+}
+
+// directorySize 统计 root 下所有文件的大小，包括缩略图和保留的原图，无法读取的文件忽略
+func directorySize(root string) int64 {
+	var total int64
+	_ = filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// megabytes 把字节数格式化为保留两位小数的 MB
+func megabytes(n int64) string {
+	return strconv.FormatFloat(float64(n)/(1<<20), 'f', 2, 64)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errAlbumNotFound 相册不存在
+var errAlbumNotFound = errors.New("相册不存在！")
+
+// errImageNotFound 上传记录中没有该图片
+var errImageNotFound = errors.New("图片不存在！")
+
+// album 相册信息，CoverURL 为相册中最早加入的图片的地址，相册为空时为空字符串
+type album struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	ImageCount int       `json:"image_count"`
+	CoverURL   string    `json:"cover_url"`
+}
+
+// albumRequest 创建相册的请求体
+type albumRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// albumImageRequest 把图片加入相册的请求体，path 为图片的保存路径，例如 2023/9/1/a.png
+type albumImageRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// createAlbumHandler 创建相册，名称已经存在时返回 409
+func createAlbumHandler(context *gin.Context) {
+	var req albumRequest
+	if err := context.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请提供相册名称！"})
+		return
+	}
+
+	a, err := createAlbum(strings.TrimSpace(req.Name))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if a == nil {
+		context.JSON(http.StatusConflict, gin.H{"error": "相册已存在！"})
+		return
+	}
+	context.JSON(http.StatusCreated, gin.H{"message": "相册创建成功！", "data": a})
+}
+
+// listAlbumsHandler 列出所有相册
+func listAlbumsHandler(context *gin.Context) {
+	albums, err := listAlbums()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"data": albums})
+}
+
+// albumHandler 返回相册信息并分页列出其中的图片，按加入相册的时间排序，支持 ?page=1&per_page=50
+func albumHandler(context *gin.Context) {
+	a, ok := albumParam(context)
+	if !ok {
+		return
+	}
+	page, perPage, ok := pagination(context)
+	if !ok {
+		return
+	}
+
+	records, err := listAlbumImages(a.ID, page, perPage)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{
+		"album":    a,
+		"data":     fileEntries(records),
+		"page":     page,
+		"per_page": perPage,
+		"total":    a.ImageCount,
+	})
+}
+
+// deleteAlbumHandler 删除相册，相册中还有图片时返回 409
+func deleteAlbumHandler(context *gin.Context) {
+	a, ok := albumParam(context)
+	if !ok {
+		return
+	}
+	if a.ImageCount > 0 {
+		context.JSON(http.StatusConflict, gin.H{"error": "相册不为空，不能删除！"})
+		return
+	}
+
+	_, err := db.Exec(`DELETE FROM albums WHERE id = ?`, a.ID)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"message": "相册删除成功！"})
+}
+
+// addAlbumImageHandler 把已经上传的图片加入相册
+func addAlbumImageHandler(context *gin.Context) {
+	a, ok := albumParam(context)
+	if !ok {
+		return
+	}
+	var req albumImageRequest
+	if err := context.ShouldBindJSON(&req); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请提供图片路径！"})
+		return
+	}
+
+	err := addAlbumImage(a.ID, strings.TrimPrefix(req.Path, "/"))
+	if errors.Is(err, errImageNotFound) {
+		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"message": "已加入相册！"})
+}
+
+// albumParam 读取路径中的相册 ID 并查询相册，相册不存在时返回 404 并且 ok 为 false
+func albumParam(context *gin.Context) (*album, bool) {
+	id, err := strconv.ParseInt(context.Param("id"), 10, 64)
+	if err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": errAlbumNotFound.Error()})
+		return nil, false
+	}
+	a, err := findAlbum(id)
+	if errors.Is(err, errAlbumNotFound) {
+		context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return a, true
+}
+
+// createAlbum 创建名为 name 的相册，名称已经存在时返回 nil
+func createAlbum(name string) (*album, error) {
+	now := time.Now()
+	result, err := db.Exec(`INSERT INTO albums (name, created_at) VALUES (?, ?) ON CONFLICT (name) DO NOTHING`, name, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &album{ID: id, Name: name, CreatedAt: time.Unix(now.Unix(), 0)}, nil
+}
+
+// albumQuery 查询相册以及图片数量、封面，之后需要拼接 WHERE 或 ORDER BY
+const albumQuery = `SELECT albums.id, albums.name, albums.created_at,
+		(SELECT COUNT(*) FROM album_images WHERE album_id = albums.id),
+		(SELECT images.stored_path FROM album_images JOIN images ON images.id = album_images.image_id
+			WHERE album_images.album_id = albums.id
+			ORDER BY album_images.added_at, album_images.image_id LIMIT 1)
+	FROM albums`
+
+// findAlbum 查询 ID 为 id 的相册，不存在时返回 errAlbumNotFound
+func findAlbum(id int64) (*album, error) {
+	a, err := scanAlbum(db.QueryRow(albumQuery+` WHERE albums.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errAlbumNotFound
+	}
+	return a, err
+}
+
+// listAlbums 按创建顺序列出所有相册
+func listAlbums() ([]*album, error) {
+	rows, err := db.Query(albumQuery + ` ORDER BY albums.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []*album{}
+	for rows.Next() {
+		a, err := scanAlbum(rows)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+// scanAlbum 读取一行 albumQuery 的结果
+func scanAlbum(row interface{ Scan(...any) error }) (*album, error) {
+	var a album
+	var createdAt int64
+	var cover sql.NullString
+	err := row.Scan(&a.ID, &a.Name, &createdAt, &a.ImageCount, &cover)
+	if err != nil {
+		return nil, err
+	}
+	a.CreatedAt = time.Unix(createdAt, 0)
+	if cover.Valid {
+		a.CoverURL = Storage.URL(cover.String)
+	}
+	return &a, nil
+}
+
+// listAlbumImages 按加入相册的顺序分页列出相册中的图片
+func listAlbumImages(albumID int64, page int, perPage int) ([]*imageRecord, error) {
+	rows, err := db.Query(`SELECT `+imageColumns+`
+		FROM album_images JOIN images ON images.id = album_images.image_id
+		WHERE album_images.album_id = ?
+		ORDER BY album_images.added_at, album_images.image_id LIMIT ? OFFSET ?`,
+		albumID, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanImages(rows)
+}
+
+// addAlbumImage 把保存路径为 path 的图片加入相册，图片已经在相册中时不做任何操作
+func addAlbumImage(albumID int64, path string) error {
+	result, err := db.Exec(`INSERT INTO album_images (album_id, image_id, added_at)
+		SELECT ?, id, ? FROM images WHERE stored_path = ?
+		ON CONFLICT (album_id, image_id) DO NOTHING`,
+		albumID, time.Now().Unix(), path)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// 没有插入时区分图片不存在和已经在相册中
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM images WHERE stored_path = ?)`, path).Scan(&exists)
+	if err == nil && !exists {
+		err = errImageNotFound
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// apiKeyAuth 校验请求头中的 API_KEY，支持 Authorization: Bearer <key> 和 X-API-Key: <key>。
+// required 为 false 时，未配置 API_KEY 则不做校验；为 true 时未配置 API_KEY 的接口不可用
+func apiKeyAuth(required bool) gin.HandlerFunc {
+	return keyAuth(APIKey, "API_KEY", required)
+}
+
+// adminAuth 校验管理接口的 ADMIN_KEY，未配置时管理接口不可用
+func adminAuth() gin.HandlerFunc {
+	return keyAuth(AdminKey, "ADMIN_KEY", true)
+}
+
+// userIDKey 通过 JWT 登录的请求在 gin.Context 中保存用户 ID 的键
+const userIDKey = "user_id"
+
+// userAuth 配置了 JWT_SECRET 时要求请求带有 Authorization: Bearer <JWT>，登录的用户 ID 通过 requestUserID 获取。
+// 为了兼容已有的上传工具，也接受 name 对应的密钥 expected。未配置 JWT_SECRET 时与 keyAuth 相同
+func userAuth(expected string, name string, required bool) gin.HandlerFunc {
+	fallback := keyAuth(expected, name, required)
+	return func(context *gin.Context) {
+		if JWTSecret == "" {
+			fallback(context)
+			return
+		}
+
+		token := requestAPIKey(context)
+		if expected != "" && validKey(token, expected) {
+			context.Next()
+			return
+		}
+		userID, err := parseToken(token)
+		if err != nil {
+			if token == "" {
+				err = errors.New("请先登录！")
+			}
+			context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		context.Set(userIDKey, userID)
+		context.Next()
+	}
+}
+
+// requestUserID 返回通过 JWT 登录的用户 ID，使用密钥访问或未登录时返回 nil
+func requestUserID(context *gin.Context) *int64 {
+	if id, ok := context.Get(userIDKey); ok {
+		userID := id.(int64)
+		return &userID
+	}
+	return nil
+}
+
+// keyAuth 校验请求中的密钥是否为 expected，name 为对应的环境变量名
+func keyAuth(expected string, name string, required bool) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if expected == "" {
+			if required {
+				context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "请先配置 " + name + "！"})
+				return
+			}
+			context.Next()
+			return
+		}
+
+		if !validKey(requestAPIKey(context), expected) {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		context.Next()
+	}
+}
+
+// requestAPIKey 从请求头中取出客户端提供的密钥，支持 X-API-Key、Bearer 以及 Basic 认证的密码（用户名任意）
+func requestAPIKey(context *gin.Context) string {
+	if key := context.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	auth := context.GetHeader("Authorization")
+	if key, found := strings.CutPrefix(auth, "Bearer "); found {
+		return strings.TrimSpace(key)
+	}
+	if _, password, ok := context.Request.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// validKey 使用固定时间比较，避免通过响应时间猜测密钥
+func validKey(key string, expected string) bool {
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1
+}
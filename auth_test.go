@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newAuthRouter 返回只有一个受 auth 保护的 GET /protected 接口的路由
+func newAuthRouter(auth gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/protected", auth, func(context *gin.Context) {
+		context.String(http.StatusOK, "ok")
+	})
+	return router
+}
+
+func TestKeyAuth(t *testing.T) {
+	router := newAuthRouter(keyAuth("secret", "API_KEY", false))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"missing key", "", "", http.StatusUnauthorized},
+		{"wrong key", "X-API-Key", "wrong", http.StatusUnauthorized},
+		{"wrong bearer", "Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"key prefix", "X-API-Key", "secre", http.StatusUnauthorized},
+		{"valid key", "X-API-Key", "secret", http.StatusOK},
+		{"valid bearer", "Authorization", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyAuthNotConfigured(t *testing.T) {
+	// 未配置密钥时，可选的接口不校验，必须配置的接口不可用
+	optional := newAuthRouter(keyAuth("", "API_KEY", false))
+	w := httptest.NewRecorder()
+	optional.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/protected", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("optional status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	required := newAuthRouter(keyAuth("", "ADMIN_KEY", true))
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("X-API-Key", "anything")
+	w = httptest.NewRecorder()
+	required.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("required status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gen2brain/avif"
+	"image"
+	"io"
+	"sync"
+	"time"
+)
+
+// errEncodeTimeout AVIF 编码超过 AVIFTimeout，或者 GIF 动图转换超过 GIFConvertTimeout
+var errEncodeTimeout = errors.New("image encoding timed out")
+
+// avifSemaphore 限制同时进行的 AVIF 编码数量，第一次使用时按 AVIFConcurrency 创建
+var avifSemaphore = sync.OnceValue(func() chan struct{} {
+	return make(chan struct{}, AVIFConcurrency)
+})
+
+// encodeAVIF 把 img 编码为 AVIF 写入 w，排队和编码的总时间超过 AVIFTimeout 时返回 errEncodeTimeout。
+// 超时后编码仍会在后台完成并一直占用名额，这样同时编码的数量不会超过 AVIFConcurrency
+func encodeAVIF(w io.Writer, img image.Image) error {
+	timeout := time.NewTimer(AVIFTimeout)
+	defer timeout.Stop()
+
+	semaphore := avifSemaphore()
+	select {
+	case semaphore <- struct{}{}:
+	case <-timeout.C:
+		return errEncodeTimeout
+	}
+
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-semaphore }()
+		done <- avif.Encode(&buf, img, avif.Options{Quality: AVIFQuality, QualityAlpha: AVIFQuality, Speed: AVIFSpeed})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteTo(w)
+		return err
+	case <-timeout.C:
+		return errEncodeTimeout
+	}
+}
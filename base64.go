@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// base64Request base64 上传的请求体，data 可以是纯 base64 也可以是 data URI，
+// filename 可以省略，省略时按时间生成文件名
+type base64Request struct {
+	Filename string `json:"filename"`
+	Data     string `json:"data" binding:"required"`
+}
+
+// base64Handler 上传 base64 编码的图片，图片类型根据解码后的内容判断，不使用 data URI 中的类型
+func base64Handler(context *gin.Context) {
+	// base64 编码后大约是原来的 4/3，另外留出 JSON 和 data URI 前缀的空间
+	limit := maxUploadSize()*4/3 + 100
+	if context.Request.ContentLength > limit {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fileTooLargeMessage()})
+		return
+	}
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, limit)
+
+	var req base64Request
+	if err := context.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fileTooLargeMessage()})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请提供 data！"})
+		return
+	}
+
+	data, uploadErr := decodeBase64Image(req.Data)
+	if uploadErr != nil {
+		respondUploadError(context, uploadErr)
+		return
+	}
+
+	result, uploadErr := saveImage(context, req.Filename, bytes.NewReader(data), int64(len(data)), requestUploadOptions(context))
+	if uploadErr != nil {
+		respondUploadError(context, uploadErr)
+		return
+	}
+	context.JSON(http.StatusOK,
+		gin.H{
+			"message": "图片上传成功！",
+			"data":    result,
+		},
+	)
+}
+
+// decodeBase64Image 解码 base64 或 data URI（data:image/png;base64,...）
+func decodeBase64Image(s string) ([]byte, *uploadError) {
+	if strings.HasPrefix(s, "data:") {
+		i := strings.Index(s, ",")
+		if i < 0 || !strings.HasSuffix(s[:i], ";base64") {
+			return nil, &uploadError{http.StatusBadRequest, "data URI 格式错误，仅支持 base64 编码！"}
+		}
+		s = s[i+1:]
+	}
+
+	// 先根据编码长度估算，避免解码过大的数据
+	if int64(base64.StdEncoding.DecodedLen(len(s))) > maxUploadSize()+2 {
+		return nil, &uploadError{http.StatusBadRequest, fileTooLargeMessage()}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "base64 解码失败：" + err.Error()}
+	}
+	return data, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/buckket/go-blurhash"
+	"github.com/disintegration/imaging"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// previewSize 计算 BlurHash 前把图片缩小到的最大宽高，占位图只需要很低的分辨率
+const previewSize = 64
+
+// dominantColorSize 计算主色调时采样的宽高
+const dominantColorSize = 32
+
+// decodePreview 解码图片并缩小到不超过 previewSize，用于计算占位图和主色调，无法解码时返回 nil
+func decodePreview(source func() (io.Reader, error)) image.Image {
+	r, err := source()
+	if err != nil {
+		return nil
+	}
+	img, _, err := decodeImage(r)
+	if err != nil {
+		return nil
+	}
+	return imaging.Fit(img, previewSize, previewSize, imaging.Box)
+}
+
+// imageBlurHash 计算图片的 BlurHash（4×3 个分量），用于图片加载前显示的占位图，失败时返回空字符串
+func imageBlurHash(img image.Image) string {
+	hash, err := blurhash.Encode(4, 3, img)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// dominantColor 把图片缩小到 dominantColorSize×dominantColorSize 并叠加到白色背景上，
+// 每个通道量化为 16 级后取像素最多的颜色，返回其中像素的平均颜色 #rrggbb
+func dominantColor(img image.Image) string {
+	sample := imaging.Resize(img, dominantColorSize, dominantColorSize, imaging.Box)
+	background := imaging.New(dominantColorSize, dominantColorSize, color.White)
+	sample = imaging.Overlay(background, sample, image.Point{}, 1)
+
+	type bucket struct {
+		r, g, b, count int
+	}
+	var buckets [4096]bucket
+	best := 0
+	for i := 0; i < len(sample.Pix); i += 4 {
+		r, g, b := int(sample.Pix[i]), int(sample.Pix[i+1]), int(sample.Pix[i+2])
+		key := r>>4<<8 | g>>4<<4 | b>>4
+		buckets[key].r += r
+		buckets[key].g += g
+		buckets[key].b += b
+		buckets[key].count++
+		if buckets[key].count > buckets[best].count {
+			best = key
+		}
+	}
+	dominant := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", dominant.r/dominant.count, dominant.g/dominant.count, dominant.b/dominant.count)
+}
+
+// blurHashCacheSize blurHashCache 最多缓存的图片数量
+const blurHashCacheSize = 4096
+
+// blurHashCache 没有上传记录或者无法解码的图片的 BlurHash，按最近使用淘汰
+var blurHashCache = newHashCache(blurHashCacheSize)
+
+// hashCache 容量固定的 LRU 缓存，保存字符串键对应的哈希
+type hashCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type hashCacheEntry struct {
+	key  string
+	hash string
+}
+
+func newHashCache(size int) *hashCache {
+	return &hashCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get 返回 key 对应的哈希，命中时把它移到最近使用的位置
+func (c *hashCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*hashCacheEntry).hash, true
+}
+
+// add 保存 key 对应的哈希，超过容量时删除最久没有使用的项
+func (c *hashCache) add(key string, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*hashCacheEntry).hash = hash
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&hashCacheEntry{key, hash})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*hashCacheEntry).key)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingSeeker 记录 Seek 被调用的次数，用来判断是否重新解码了图片
+type countingSeeker struct {
+	io.ReadSeeker
+	seeks int
+}
+
+func (s *countingSeeker) Seek(offset int64, whence int) (int64, error) {
+	s.seeks++
+	return s.ReadSeeker.Seek(offset, whence)
+}
+
+func TestStoredBlurHashDecodesOnce(t *testing.T) {
+	root := useTestStorage(t)
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "orphan.png")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有上传记录的图片，第二次请求应该直接使用缓存
+	reader := &countingSeeker{ReadSeeker: bytes.NewReader(buf.Bytes())}
+	first := storedBlurHash(ctx.Background(), "orphan.png", stat, reader)
+	if first == "" {
+		t.Fatal("storedBlurHash returned an empty hash")
+	}
+	second := storedBlurHash(ctx.Background(), "orphan.png", stat, reader)
+	if second != first {
+		t.Errorf("second hash = %q, want %q", second, first)
+	}
+	if reader.seeks != 1 {
+		t.Errorf("image decoded %d times, want 1", reader.seeks)
+	}
+}
+
+func TestHashCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newHashCache(2)
+	cache.add("a", "1")
+	cache.add("b", "2")
+	cache.get("a")
+	cache.add("c", "3")
+	if _, ok := cache.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for key, want := range map[string]string{"a": "1", "c": "3"} {
+		if got, ok := cache.get(key); !ok || got != want {
+			t.Errorf("get(%q) = %q, %v, want %q", key, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// variantCacheSize ResizeCacheDir 中缓存文件的总大小，启动时扫描得到，之后随生成和删除更新
+var variantCacheSize atomic.Int64
+
+// variantCacheFiles ResizeCacheDir 中缓存文件的数量
+var variantCacheFiles atomic.Int64
+
+// evictionMu 同一时间只进行一次淘汰或清空
+var evictionMu sync.Mutex
+
+// cachedFile 缓存目录中的一个文件
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// variantDir 图片 name 的所有缓存版本所在的目录，原图删除或替换时删除整个目录
+func variantDir(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(ResizeCacheDir, key[:2], key)
+}
+
+// scanVariantCache 扫描 dir 下的缓存文件，忽略正在写入的临时文件
+func scanVariantCache(dir string) ([]cachedFile, error) {
+	var files []cachedFile
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".variant-") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, cachedFile{name, info.Size(), info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// loadVariantCacheSize 启动时统计缓存的总大小，超过 ResizeCacheMaxSize 时淘汰最久没有访问的文件
+func loadVariantCacheSize() {
+	files, err := scanVariantCache(ResizeCacheDir)
+	if err != nil {
+		slog.Error("failed to scan variant cache", "dir", ResizeCacheDir, "error", err)
+		return
+	}
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	variantCacheSize.Store(total)
+	variantCacheFiles.Store(int64(len(files)))
+	evictVariants()
+}
+
+// touchVariant 命中缓存时更新文件的修改时间，淘汰时按修改时间判断最近使用的时间，不依赖可能被 noatime 关闭的 atime
+func touchVariant(name string) {
+	now := time.Now()
+	_ = os.Chtimes(name, now, now)
+}
+
+// addVariant 记录新生成的缓存文件，总大小超过 ResizeCacheMaxSize 时在后台淘汰
+func addVariant(size int64) {
+	variantCacheFiles.Add(1)
+	if variantCacheSize.Add(size) > ResizeCacheMaxSize && ResizeCacheMaxSize > 0 {
+		go evictVariants()
+	}
+}
+
+// evictVariants 缓存总大小超过 ResizeCacheMaxSize 时按最近使用时间从旧到新删除文件，直到不超过上限的 90%
+func evictVariants() {
+	if ResizeCacheMaxSize <= 0 || variantCacheSize.Load() <= ResizeCacheMaxSize {
+		return
+	}
+	if !evictionMu.TryLock() {
+		return
+	}
+	defer evictionMu.Unlock()
+
+	files, err := scanVariantCache(ResizeCacheDir)
+	if err != nil {
+		slog.Error("failed to scan variant cache", "dir", ResizeCacheDir, "error", err)
+		return
+	}
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	target := ResizeCacheMaxSize / 10 * 9
+	removed := 0
+	for _, file := range files {
+		if total <= target {
+			break
+		}
+		if err := os.Remove(file.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		total -= file.size
+		removed++
+	}
+	variantCacheSize.Store(total)
+	variantCacheFiles.Store(int64(len(files) - removed))
+	slog.Info("evicted variant cache", "files", removed, "size", total, "max_size", ResizeCacheMaxSize)
+}
+
+// removeVariants 删除 dir 下的所有缓存文件，返回删除的文件数和大小
+func removeVariants(dir string) (int, int64, error) {
+	files, err := scanVariantCache(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var freed int64
+	for _, file := range files {
+		freed += file.size
+	}
+	err = os.RemoveAll(dir)
+	variantCacheSize.Add(-freed)
+	variantCacheFiles.Add(-int64(len(files)))
+	return len(files), freed, err
+}
+
+// purgeVariants 原图 name 被删除或替换后删除它的所有缓存版本，失败时只记录日志
+func purgeVariants(name string) {
+	if _, _, err := removeVariants(variantDir(name)); err != nil {
+		slog.Warn("failed to purge cached variants", "path", name, "error", err)
+	}
+}
+
+// purgeCacheHandler 清空缩放、裁剪、转换格式后的图片缓存，带有 ?path= 时只删除该图片的缓存版本
+func purgeCacheHandler(context *gin.Context) {
+	evictionMu.Lock()
+	defer evictionMu.Unlock()
+
+	dir := ResizeCacheDir
+	if name := strings.TrimPrefix(context.Query("path"), "/"); name != "" {
+		dir = variantDir(name)
+	}
+	removed, freed, err := removeVariants(dir)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if dir == ResizeCacheDir {
+		// 清空整个缓存后计数直接归零，避免之前的误差累积
+		variantCacheSize.Store(0)
+		variantCacheFiles.Store(0)
+	}
+	context.JSON(http.StatusOK, gin.H{"removed": removed, "freed": freed})
+}
+
+// cacheStatsHandler 返回图片缓存的文件数、总大小和上限
+func cacheStatsHandler(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{
+		"files":    variantCacheFiles.Load(),
+		"size":     variantCacheSize.Load(),
+		"max_size": ResizeCacheMaxSize,
+	})
+}
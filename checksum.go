@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// checksumHeaders 客户端可以通过这些请求头提供上传内容的校验和，值可以是十六进制或者 base64
+var checksumHeaders = []struct {
+	header    string
+	algorithm string
+	newHash   func() hash.Hash
+}{
+	{"X-Content-SHA256", "sha256", sha256.New},
+	{"X-Content-MD5", "md5", md5.New},
+}
+
+// expectedChecksum 客户端提供的校验和，expected 为小写十六进制
+type expectedChecksum struct {
+	algorithm string
+	expected  string
+	hash      hash.Hash
+}
+
+// checksumError 上传内容的校验和与客户端提供的不一致
+type checksumError struct {
+	algorithm string
+	expected  string
+	actual    string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("%s 校验失败！期望 %s，实际 %s", e.algorithm, e.expected, e.actual)
+}
+
+// requestChecksums 读取请求头中的校验和，没有提供时返回 nil，格式错误时返回 400
+func requestChecksums(context *gin.Context) ([]*expectedChecksum, *uploadError) {
+	var checksums []*expectedChecksum
+	for _, h := range checksumHeaders {
+		value := strings.TrimSpace(context.GetHeader(h.header))
+		if value == "" {
+			continue
+		}
+		newHash := h.newHash()
+		expected, ok := parseDigest(value, newHash.Size())
+		if !ok {
+			return nil, &uploadError{http.StatusBadRequest, h.header + " 格式错误！"}
+		}
+		checksums = append(checksums, &expectedChecksum{algorithm: h.algorithm, expected: expected, hash: newHash})
+	}
+	return checksums, nil
+}
+
+// parseDigest 把十六进制或 base64 编码的摘要转换为小写十六进制，size 为摘要的字节数
+func parseDigest(value string, size int) (string, bool) {
+	if b, err := hex.DecodeString(value); err == nil && len(b) == size {
+		return hex.EncodeToString(b), true
+	}
+	if b, err := base64.StdEncoding.DecodeString(value); err == nil && len(b) == size {
+		return hex.EncodeToString(b), true
+	}
+	return "", false
+}
+
+// verifyingReader 读取时计算校验和，读到结尾时与客户端提供的不一致则返回 checksumError 代替 io.EOF，
+// 本地存储会删除保存到一半的文件，对象存储可能已经保存了完整的对象，由 saveImage 删除
+type verifyingReader struct {
+	r         io.Reader
+	checksums []*expectedChecksum
+}
+
+// withChecksums 没有提供校验和时直接返回 r
+func withChecksums(r io.Reader, checksums []*expectedChecksum) io.Reader {
+	if len(checksums) == 0 {
+		return r
+	}
+	return &verifyingReader{r: r, checksums: checksums}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	for _, c := range v.checksums {
+		c.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		for _, c := range v.checksums {
+			if actual := hex.EncodeToString(c.hash.Sum(nil)); actual != c.expected {
+				return n, &checksumError{algorithm: c.algorithm, expected: c.expected, actual: actual}
+			}
+		}
+	}
+	return n, err
+}
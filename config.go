@@ -0,0 +1,715 @@
+package main
+
+import (
+	"errors"
+	"github.com/joho/godotenv"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxFileSize 允许上传的最大文件大小（字节）
+var MaxFileSize int64 = 10 << 20 // 10 MB
+
+// SizeLimits 按图片类型（扩展名）单独设置的大小上限，没有设置的类型使用 MaxFileSize
+var SizeLimits map[string]int64
+
+// AllowOrigins 允许域
+var AllowOrigins []string
+
+// Port 端口，开启 TLS 时为 HTTP 端口，只用于跳转到 HTTPS 和 ACME HTTP-01 验证
+var Port string
+
+// TLS 模式
+const (
+	// TLSModeOff 不开启 TLS
+	TLSModeOff = "off"
+	// TLSModeManual 使用 TLS_CERT_FILE 和 TLS_KEY_FILE 指定的证书
+	TLSModeManual = "manual"
+	// TLSModeACME 通过 Let's Encrypt 自动申请和续期证书
+	TLSModeACME = "acme"
+)
+
+// TLSMode TLS 模式，默认为 TLSModeOff
+var TLSMode = TLSModeOff
+
+// TLSPort 开启 TLS 时 HTTPS 的端口
+var TLSPort = "443"
+
+// TLSCertFile TLSModeManual 时的证书文件
+var TLSCertFile string
+
+// TLSKeyFile TLSModeManual 时的私钥文件
+var TLSKeyFile string
+
+// TLSDomain TLSModeACME 时申请证书的域名，多个域名用逗号分隔
+var TLSDomain []string
+
+// TLSCacheDir TLSModeACME 时保存证书的目录
+var TLSCacheDir = "./certs"
+
+// Url 返回的图片Url前缀
+var Url string
+
+// FeedTitle /feed.rss 的标题
+var FeedTitle = "go-drawing-bed"
+
+// APIKey 接口密钥
+var APIKey string
+
+// AdminKey 管理接口密钥
+var AdminKey string
+
+// JWTSecret 签发登录 JWT 使用的密钥，为空时不启用用户注册和登录
+var JWTSecret string
+
+// JWTTTL 登录后签发的 JWT 的有效期
+var JWTTTL time.Duration
+
+// Storage 文件存储后端
+var Storage StorageBackend
+
+// ContentAddressed 是否按内容的 SHA-256 保存文件，相同内容只保存一份
+var ContentAddressed bool
+
+// Naming 保存文件时的命名方式
+var Naming = NamingOriginal
+
+// FilenameTemplate 文件保存路径模板，未配置时根据 Naming 选择
+var FilenameTemplate *pathTemplate
+
+// Deduplicate 是否按内容去重，相同内容的图片直接返回已保存的地址
+var Deduplicate bool
+
+// IndexDir 旧版本去重时保存 SHA-256 索引的目录，现在只读取
+var IndexDir = "./data/hashes"
+
+// TusDir 断点续传时保存未完成上传的目录
+var TusDir = "./uploads/.tus"
+
+// TusTTL 未完成的断点续传任务超过该时间没有更新会被删除
+var TusTTL time.Duration
+
+// ImportTimeout 通过链接上传图片时的下载超时时间
+var ImportTimeout time.Duration
+
+// ImportMaxSize 通过链接上传图片时允许下载的最大文件大小
+var ImportMaxSize int64
+
+// WebhookURL 上传成功后发送通知的地址，为空时不发送
+var WebhookURL string
+
+// WebhookSecret 对 webhook 请求体签名使用的密钥，为空时不签名
+var WebhookSecret string
+
+// BlockPrivateIPs 通过链接上传图片时是否拒绝访问内网、回环等地址
+var BlockPrivateIPs = true
+
+// UploadsPerMinute 每个 IP 每分钟允许的上传次数，为 0 时不限制
+var UploadsPerMinute int
+
+// TrustedProxies 信任的代理（IP 或 CIDR），设置后才会使用 X-Forwarded-For 中的客户端 IP，
+// 限流、访问日志和上传记录都使用解析后的客户端 IP
+var TrustedProxies []string
+
+// MaxImageWidth 图片的最大宽度，超过时按比例缩小，为 0 时不限制
+var MaxImageWidth int
+
+// MaxImageHeight 图片的最大高度，超过时按比例缩小，为 0 时不限制
+var MaxImageHeight int
+
+// ConvertTo 把上传的 JPEG、PNG 转换为该格式保存，webp 或 avif，为空时不转换
+var ConvertTo string
+
+// AVIFQuality 编码 AVIF 时的质量，1 到 100
+var AVIFQuality int
+
+// AVIFSpeed 编码 AVIF 时的速度，0 到 10，越慢压缩率越高
+var AVIFSpeed int
+
+// AVIFConcurrency 同时进行的 AVIF 编码数量
+var AVIFConcurrency int
+
+// AVIFTimeout AVIF 编码的超时时间，超时后保留原来的格式
+var AVIFTimeout time.Duration
+
+// WebPQuality 编码 WebP 时的质量，1 到 100
+var WebPQuality int
+
+// PHashThreshold 感知哈希的汉明距离不超过该值的图片会在上传结果中作为相似图片返回
+var PHashThreshold int
+
+// DominantColor 上传时是否计算图片的主色调，默认开启
+var DominantColor bool
+
+// AutoOrient 是否按 EXIF 中的 Orientation 旋转上传的 JPEG，默认关闭
+var AutoOrient bool
+
+// EXIFMode 上传的 JPEG、PNG、WebP 中元数据的处理方式，默认删除全部元数据
+var EXIFMode = EXIFModeStrip
+
+// JPEGQuality 重新压缩 JPEG 时使用的质量，1 到 100，为 0 时不重新压缩
+var JPEGQuality int
+
+// JPEGRecompressMinSize 配置了 JPEGQuality 时只重新压缩不小于该字节数的 JPEG
+var JPEGRecompressMinSize int64
+
+// WatermarkText 水印文字，为空时不添加，配置了 WatermarkFile 时使用图片水印
+var WatermarkText string
+
+// WatermarkFile 水印图片的路径（建议使用带透明通道的 PNG），为空时不添加
+var WatermarkFile string
+
+// WatermarkPosition 水印的位置，默认为 WatermarkBottomRight
+var WatermarkPosition = WatermarkBottomRight
+
+// WatermarkMargin 水印与图片边缘的距离（像素），小于 0 时文字水印使用字号、图片水印使用 16
+var WatermarkMargin int
+
+// WatermarkOpacity 水印的不透明度，0.0 到 1.0
+var WatermarkOpacity = 0.5
+
+// ResponseLinks 上传成功后是否返回 Markdown、BBCode、HTML 格式的链接
+var ResponseLinks bool
+
+// AllowedTypes 允许上传的图片类型（扩展名），为 nil 时允许所有图片类型
+var AllowedTypes map[string]bool
+
+// ThumbnailSize 缩略图最长边的像素数
+var ThumbnailSize int
+
+// ResizeCacheDir 通过 ?w=&h= 访问时缓存缩放后图片的目录，位于 ./static 下时不能通过 /static 直接访问
+var ResizeCacheDir = "./static/.cache"
+
+// ResizeCacheMaxSize ResizeCacheDir 中缓存的总大小上限（字节），超过时淘汰最久没有访问的文件，为 0 时不限制
+var ResizeCacheMaxSize int64
+
+// ResizeConcurrency 缩放、裁剪和转换格式时同时解码的图片数量
+var ResizeConcurrency int
+
+// AllowSVG 是否允许上传 SVG，保存前会删除其中的脚本和外部引用
+var AllowSVG bool
+
+// HEICQuality HEIC/HEIF 转换为 JPEG 时使用的质量，1 到 100，为 0 时使用 JPEGQuality
+var HEICQuality int
+
+// KeepHEICOriginal HEIC/HEIF 转换为 JPEG 后是否同时保存原图
+var KeepHEICOriginal bool
+
+// GIFConvert 上传的动图 GIF 转换后的格式，webp 或 mp4，为空时不转换
+var GIFConvert string
+
+// GIFConvertTimeout 转换一张动图 GIF 的最长时间，超过时保存原图
+var GIFConvertTimeout time.Duration
+
+// KeepGIFOriginal 动图 GIF 转换后是否同时保存原图
+var KeepGIFOriginal bool
+
+// AllowedMIMETypes 允许上传的 MIME 类型，支持 image/* 这样的通配符，为 nil 时只允许图片
+var AllowedMIMETypes []string
+
+// MaxWidth 允许上传的图片最大宽度，超过时拒绝上传，为 0 时不限制
+var MaxWidth int
+
+// MaxHeight 允许上传的图片最大高度，超过时拒绝上传，为 0 时不限制
+var MaxHeight int
+
+// MaxPixels 允许上传以及解码的图片最大像素数（宽×高），为 0 时不限制
+var MaxPixels int64 = 50_000_000
+
+// StrictDimensions 无法识别尺寸的图片是否拒绝上传
+var StrictDimensions bool
+
+// URLSigningSecret 生成带有效期的图片地址时使用的密钥
+var URLSigningSecret string
+
+// RequireSignedURLs 访问图片时是否必须使用签名地址
+var RequireSignedURLs bool
+
+// MetricsToken 访问 /metrics 时需要的密钥，未配置时不能访问
+var MetricsToken string
+
+// DiskFreeMinBytes 健康检查要求存储目录所在磁盘至少剩余的字节数
+var DiskFreeMinBytes int64 = 100 << 20
+
+// DiskCheckInterval 后台检查存储目录剩余空间的间隔
+var DiskCheckInterval time.Duration
+
+// DiskAlertThreshold 存储目录所在磁盘剩余空间低于该字节数时记录 ERROR 日志
+var DiskAlertThreshold int64
+
+// DiskCriticalThreshold 存储目录所在磁盘剩余空间低于该字节数时上传接口直接返回 507
+var DiskCriticalThreshold int64
+
+// AsyncProcessing 是否先保存原图，再在后台进行缩放、水印、格式转换和生成缩略图
+var AsyncProcessing bool
+
+// ProcessingWorkers 后台处理图片的 worker 数量
+var ProcessingWorkers int
+
+// ProcessingQueueSize 后台处理队列的容量，队列已满时上传返回 429
+var ProcessingQueueSize int
+
+// ZipMaxSize 上传 ZIP 压缩包时压缩包的最大大小
+var ZipMaxSize int64
+
+// ZipMaxEntries ZIP 压缩包中最多允许的条目数，超过时拒绝整个压缩包
+var ZipMaxEntries int
+
+// ZipMaxTotalSize ZIP 压缩包中所有文件解压后的最大总大小
+var ZipMaxTotalSize int64
+
+// ShutdownTimeout 收到退出信号后等待正在处理的请求完成的最长时间
+var ShutdownTimeout time.Duration
+
+// HSTSHeader 开启 TLS 时 Strict-Transport-Security 响应头的值，为空时不设置
+var HSTSHeader string
+
+// ContentTypeOptions X-Content-Type-Options 响应头的值，为空时不设置
+var ContentTypeOptions string
+
+// FrameOptions X-Frame-Options 响应头的值，为空时不设置
+var FrameOptions string
+
+// ReferrerPolicy Referrer-Policy 响应头的值，为空时不设置
+var ReferrerPolicy string
+
+// CSPHeader Content-Security-Policy 响应头的值，默认不设置
+var CSPHeader string
+
+// TracingEnabled 是否配置了 OTEL_EXPORTER_OTLP_ENDPOINT，开启时把上传和存储操作的追踪数据导出到该地址
+var TracingEnabled bool
+
+// LogFormat 日志格式，text（默认）或 json
+var LogFormat string
+
+// DBPath SQLite 数据库文件路径
+var DBPath = "./data.db"
+
+func init() {
+	err := godotenv.Load()
+	LogFormat = os.Getenv("LOG_FORMAT")
+	if LogFormat == "" {
+		LogFormat = LogFormatText
+	}
+	slog.SetDefault(newLogger(LogFormat))
+	if LogFormat != LogFormatText && LogFormat != LogFormatJSON {
+		fatal("invalid LOG_FORMAT", "value", LogFormat)
+	}
+	// 没有 .env 时只使用环境变量，.env 存在但格式错误时退出
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal("error loading .env file", "error", err)
+	}
+	if v := os.Getenv("MAX_FILE_SIZE_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 1 || mb > 100 {
+			fatal("invalid MAX_FILE_SIZE_MB: must be between 1 and 100", "value", v)
+		}
+		MaxFileSize = int64(mb) << 20
+	}
+	if v := os.Getenv("MAX_FILE_SIZE"); v != "" {
+		MaxFileSize, err = parseSize(v)
+		if err != nil {
+			fatal("invalid MAX_FILE_SIZE", "value", v, "error", err)
+		}
+	}
+	if v := os.Getenv("SIZE_LIMITS"); v != "" {
+		SizeLimits = map[string]int64{}
+		for _, entry := range strings.Split(v, ",") {
+			t, size, found := strings.Cut(entry, ":")
+			t = normalizeType(t)
+			limit, err := parseSize(size)
+			if !found || t == "" || err != nil {
+				fatal("invalid SIZE_LIMITS entry, expected <type>:<size>", "value", entry)
+			}
+			if t == "default" {
+				MaxFileSize = limit
+				continue
+			}
+			SizeLimits[t] = limit
+		}
+	}
+	if v := os.Getenv("TLS_MODE"); v != "" {
+		TLSMode = strings.ToLower(v)
+	}
+	if v := os.Getenv("TLS_PORT"); v != "" {
+		TLSPort = v
+	}
+	switch TLSMode {
+	case TLSModeOff:
+	case TLSModeManual:
+		TLSCertFile = os.Getenv("TLS_CERT_FILE")
+		TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+		if TLSCertFile == "" || TLSKeyFile == "" {
+			fatal("TLS_MODE=manual requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+	case TLSModeACME:
+		for _, domain := range strings.Split(os.Getenv("TLS_DOMAIN"), ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				TLSDomain = append(TLSDomain, domain)
+			}
+		}
+		if len(TLSDomain) == 0 {
+			fatal("TLS_MODE=acme requires TLS_DOMAIN")
+		}
+		if v := os.Getenv("TLS_CACHE_DIR"); v != "" {
+			TLSCacheDir = v
+		}
+	default:
+		fatal("invalid TLS_MODE, expected off, manual or acme", "value", TLSMode)
+	}
+	Port = os.Getenv("PORT")
+	if Port == "" && TLSMode == TLSModeACME {
+		// HTTP-01 验证必须通过 80 端口访问
+		Port = "80"
+	}
+	if Port == "" {
+		Port = "8080"
+	}
+	if TLSMode == TLSModeACME && Port != "80" {
+		slog.Warn("TLS_MODE=acme needs port 80 to be reachable from the internet for HTTP-01 challenges, make sure it is forwarded to PORT", "port", Port)
+	}
+	AllowOrigins = strings.Split(os.Getenv("AllowOrigins"), ",")
+	Url = os.Getenv("URL")
+	if v := os.Getenv("FEED_TITLE"); v != "" {
+		FeedTitle = v
+	}
+	if Url == "" {
+		switch TLSMode {
+		case TLSModeACME:
+			Url = "https://" + TLSDomain[0]
+		case TLSModeManual:
+			Url = "https://127.0.0.1:" + TLSPort
+		default:
+			Url = "http://127.0.0.1:" + Port
+		}
+	}
+	APIKey = os.Getenv("API_KEY")
+	if APIKey == "" {
+		slog.Warn("API_KEY is not set, upload authentication is disabled")
+	}
+	AdminKey = os.Getenv("ADMIN_KEY")
+	JWTSecret = os.Getenv("JWT_SECRET")
+	if JWTSecret != "" && len(JWTSecret) < 32 {
+		slog.Warn("JWT_SECRET is shorter than 32 bytes, tokens may be brute-forced")
+	}
+	JWTTTL = time.Duration(envInt("JWT_TTL_HOURS", 24, 1)) * time.Hour
+	// STORAGE 是 STORAGE_BACKEND 的简写
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		backend = os.Getenv("STORAGE")
+	}
+	Storage, err = NewStorageBackend(backend)
+	if err != nil {
+		fatal("error creating storage backend", "error", err)
+	}
+	ContentAddressed = os.Getenv("CONTENT_ADDRESSED") == "true"
+	switch v := os.Getenv("NAMING"); v {
+	case "", NamingOriginal:
+	case NamingHash, NamingUUID:
+		Naming = v
+	default:
+		fatal("invalid NAMING", "value", v)
+	}
+	// STORAGE_PATH_TEMPLATE 是 FILENAME_TEMPLATE 的新名称，两者都配置时以 STORAGE_PATH_TEMPLATE 为准
+	template := os.Getenv("STORAGE_PATH_TEMPLATE")
+	if template == "" {
+		template = os.Getenv("FILENAME_TEMPLATE")
+	}
+	if template == "" {
+		template = namingTemplates[Naming]
+	}
+	FilenameTemplate, err = parsePathTemplate(template)
+	if err != nil {
+		fatal("invalid STORAGE_PATH_TEMPLATE", "error", err)
+	}
+	slog.Info("storage path template", "template", FilenameTemplate.String(), "placeholders", placeholderList())
+	Deduplicate = os.Getenv("DEDUPLICATE") == "true"
+	if v := os.Getenv("INDEX_DIR"); v != "" {
+		IndexDir = v
+	}
+	ImportTimeout = time.Duration(envInt("IMPORT_TIMEOUT_SECONDS", 10, 1)) * time.Second
+	importClient.Timeout = ImportTimeout
+	BlockPrivateIPs = os.Getenv("BLOCK_PRIVATE_IPS") != "false"
+	WebhookURL = os.Getenv("WEBHOOK_URL")
+	if WebhookURL != "" {
+		if u, err := url.Parse(WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("invalid WEBHOOK_URL", "value", WebhookURL)
+		}
+	}
+	WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if v := os.Getenv("TUS_DIR"); v != "" {
+		TusDir = v
+	}
+	TusTTL = time.Duration(envInt("TUS_TTL_HOURS", 24, 1)) * time.Hour
+	ShutdownTimeout = time.Duration(envInt("SHUTDOWN_TIMEOUT_SECONDS", 30, 0)) * time.Second
+	TracingEnabled = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != ""
+	HSTSHeader = envHeader("HSTS_HEADER", "max-age=31536000; includeSubDomains")
+	ContentTypeOptions = envHeader("X_CONTENT_TYPE_OPTIONS", "nosniff")
+	FrameOptions = envHeader("X_FRAME_OPTIONS", "SAMEORIGIN")
+	ReferrerPolicy = envHeader("REFERRER_POLICY", "strict-origin-when-cross-origin")
+	CSPHeader = envHeader("CSP_HEADER", "")
+	ImportMaxSize = int64(envInt("IMPORT_MAX_SIZE", int(MaxFileSize), 1))
+	UploadsPerMinute = envInt("RATE_LIMIT_UPLOADS_PER_MINUTE", 20, 0)
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "*" {
+			// 信任所有地址时客户端可以通过 X-Forwarded-For 伪造 IP 绕过限流
+			slog.Warn("TRUSTED_PROXIES=* trusts X-Forwarded-For from any client, client IPs can be spoofed to bypass rate limiting")
+			TrustedProxies = append(TrustedProxies, "0.0.0.0/0", "::/0")
+		} else if proxy != "" {
+			TrustedProxies = append(TrustedProxies, proxy)
+		}
+	}
+	MaxImageWidth = envInt("MAX_IMAGE_WIDTH", 0, 0)
+	MaxImageHeight = envInt("MAX_IMAGE_HEIGHT", 0, 0)
+	ConvertTo = strings.ToLower(os.Getenv("CONVERT_TO"))
+	if ConvertTo == "" && os.Getenv("CONVERT_TO_WEBP") == "true" {
+		ConvertTo = "webp"
+	}
+	if ConvertTo != "" && ConvertTo != "webp" && ConvertTo != "avif" {
+		fatal("invalid CONVERT_TO, expected webp or avif", "value", ConvertTo)
+	}
+	AVIFQuality = envInt("AVIF_QUALITY", 60, 1)
+	if AVIFQuality > 100 {
+		fatal("invalid AVIF_QUALITY: must be between 1 and 100")
+	}
+	AVIFSpeed = envInt("AVIF_SPEED", 8, 0)
+	if AVIFSpeed > 10 {
+		fatal("invalid AVIF_SPEED: must be between 0 and 10")
+	}
+	AVIFConcurrency = envInt("AVIF_CONCURRENCY", runtime.NumCPU(), 1)
+	AVIFTimeout = time.Duration(envInt("AVIF_TIMEOUT_SECONDS", 30, 1)) * time.Second
+	WebPQuality = envInt("WEBP_QUALITY", 80, 1)
+	if WebPQuality > 100 {
+		fatal("invalid WEBP_QUALITY: must be between 1 and 100")
+	}
+	AutoOrient = os.Getenv("AUTO_ORIENT") == "true"
+	DominantColor = os.Getenv("DOMINANT_COLOR") != "false"
+	PHashThreshold = envInt("PHASH_THRESHOLD", 5, 0)
+	if PHashThreshold > 64 {
+		fatal("invalid PHASH_THRESHOLD: must be between 0 and 64")
+	}
+	// STRIP_EXIF=false 是 EXIF_MODE=keep 的旧写法
+	if os.Getenv("STRIP_EXIF") == "false" {
+		EXIFMode = EXIFModeKeep
+	}
+	switch v := os.Getenv("EXIF_MODE"); v {
+	case "":
+	case EXIFModeStrip, EXIFModeStripGPS, EXIFModeKeep:
+		EXIFMode = v
+	default:
+		fatal("invalid EXIF_MODE, expected strip, strip-gps or keep", "value", v)
+	}
+	JPEGQuality = envInt("JPEG_QUALITY", 0, 0)
+	if JPEGQuality > 100 {
+		fatal("invalid JPEG_QUALITY: must be between 1 and 100")
+	}
+	JPEGRecompressMinSize = envSize("JPEG_RECOMPRESS_MIN_SIZE", 1<<20)
+	WatermarkText = os.Getenv("WATERMARK_TEXT")
+	ResponseLinks = os.Getenv("RESPONSE_LINKS") != "false"
+	if v := os.Getenv("ALLOWED_TYPES"); v != "" {
+		AllowedTypes = map[string]bool{}
+		for _, t := range strings.Split(v, ",") {
+			if t = normalizeType(t); t != "" {
+				AllowedTypes[t] = true
+			}
+		}
+	}
+	HEICQuality = envInt("HEIC_JPEG_QUALITY", 0, 0)
+	if HEICQuality > 100 {
+		fatal("invalid HEIC_JPEG_QUALITY: must be between 1 and 100")
+	}
+	KeepHEICOriginal = os.Getenv("KEEP_HEIC_ORIGINAL") == "true"
+	GIFConvert = strings.ToLower(os.Getenv("GIF_CONVERT"))
+	switch GIFConvert {
+	case "", GIFConvertWebP:
+	case GIFConvertMP4:
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			fatal("GIF_CONVERT=mp4 requires ffmpeg in PATH", "error", err)
+		}
+	default:
+		fatal("invalid GIF_CONVERT, expected webp or mp4", "value", GIFConvert)
+	}
+	GIFConvertTimeout = time.Duration(envInt("GIF_CONVERT_TIMEOUT_SECONDS", 30, 1)) * time.Second
+	KeepGIFOriginal = os.Getenv("KEEP_GIF_ORIGINAL") == "true"
+	AllowSVG = os.Getenv("ALLOW_SVG") == "true"
+	if v := os.Getenv("ALLOWED_EXTRA_TYPES"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if err := allowExtraType(strings.TrimSpace(name)); err != nil {
+				fatal("invalid ALLOWED_EXTRA_TYPES", "error", err)
+			}
+		}
+	}
+	ThumbnailSize = envInt("THUMBNAIL_SIZE", 320, 1)
+	if v := os.Getenv("RESIZE_CACHE_DIR"); v != "" {
+		ResizeCacheDir = v
+	}
+	ResizeCacheMaxSize = int64(envInt("RESIZE_CACHE_MAX_MB", 1024, 0)) << 20
+	ResizeConcurrency = envInt("RESIZE_CONCURRENCY", runtime.NumCPU(), 1)
+	if v := os.Getenv("ALLOWED_MIME_TYPES"); v != "" {
+		for _, pattern := range strings.Split(v, ",") {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				fatal("invalid ALLOWED_MIME_TYPES entry", "value", pattern)
+			}
+			AllowedMIMETypes = append(AllowedMIMETypes, pattern)
+		}
+	}
+	MaxWidth = envInt("MAX_WIDTH", 0, 0)
+	MaxHeight = envInt("MAX_HEIGHT", 0, 0)
+	// MAX_PIXELS 是 MAX_IMAGE_PIXELS 的旧名称，默认限制 5000 万像素，防止解码时耗尽内存
+	MaxPixels = int64(envInt("MAX_IMAGE_PIXELS", envInt("MAX_PIXELS", 50_000_000, 0), 0))
+	StrictDimensions = os.Getenv("STRICT_DIMENSIONS") == "true"
+	URLSigningSecret = os.Getenv("URL_SIGNING_SECRET")
+	RequireSignedURLs = os.Getenv("REQUIRE_SIGNED_URLS") == "true"
+	if RequireSignedURLs && URLSigningSecret == "" {
+		fatal("REQUIRE_SIGNED_URLS is enabled but URL_SIGNING_SECRET is not set")
+	}
+	MetricsToken = os.Getenv("METRICS_TOKEN")
+	AsyncProcessing = os.Getenv("ASYNC_PROCESSING") == "true"
+	if AsyncProcessing && ContentAddressed {
+		fatal("ASYNC_PROCESSING can not be used together with CONTENT_ADDRESSED")
+	}
+	ProcessingWorkers = envInt("PROCESSING_WORKERS", 2, 1)
+	ProcessingQueueSize = envInt("PROCESSING_QUEUE_SIZE", 100, 1)
+	if v := os.Getenv("DISK_FREE_MIN_BYTES"); v != "" {
+		DiskFreeMinBytes, err = parseSize(v)
+		if err != nil {
+			fatal("invalid DISK_FREE_MIN_BYTES", "value", v, "error", err)
+		}
+	}
+	DiskCheckInterval = time.Duration(envInt("DISK_CHECK_INTERVAL_SECONDS", 60, 1)) * time.Second
+	DiskAlertThreshold = int64(envInt("DISK_ALERT_THRESHOLD_MB", 500, 0)) << 20
+	DiskCriticalThreshold = int64(envInt("DISK_CRITICAL_MB", 100, 0)) << 20
+	ZipMaxSize = envSize("ZIP_MAX_SIZE", 100<<20)
+	ZipMaxEntries = envInt("MAX_ZIP_FILES", envInt("ZIP_MAX_ENTRIES", 100, 1), 1)
+	ZipMaxTotalSize = envSize("ZIP_MAX_TOTAL_SIZE", 1<<30)
+	if v := os.Getenv("DB_PATH"); v != "" {
+		DBPath = v
+	}
+	db, err = openDB(DBPath)
+	if err != nil {
+		fatal("error opening database", "error", err)
+	}
+	WatermarkFile = os.Getenv("WATERMARK_FILE")
+	if WatermarkFile != "" {
+		watermarkImage, err = loadWatermarkImage(WatermarkFile)
+		if err != nil {
+			fatal("error loading WATERMARK_FILE", "path", WatermarkFile, "error", err)
+		}
+	}
+	if v := os.Getenv("WATERMARK_POSITION"); v != "" {
+		WatermarkPosition = strings.ToLower(v)
+		if !watermarkPositions[WatermarkPosition] {
+			fatal("invalid WATERMARK_POSITION, expected top-left, top-right, bottom-left, bottom-right or center", "value", v)
+		}
+	}
+	WatermarkMargin = envInt("WATERMARK_MARGIN", -1, 0)
+	if v := os.Getenv("WATERMARK_OPACITY"); v != "" {
+		WatermarkOpacity, err = strconv.ParseFloat(v, 64)
+		if err != nil || WatermarkOpacity < 0 || WatermarkOpacity > 1 {
+			fatal("invalid WATERMARK_OPACITY: must be between 0.0 and 1.0")
+		}
+	}
+}
+
+// envInt 读取整数类型的环境变量，未设置时返回 def，格式错误或小于 minValue 时退出
+func envInt(key string, def int, minValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < minValue {
+		fatal("invalid "+key, "value", v)
+	}
+	return n
+}
+
+// envSize 读取大小类型的环境变量（例如 100MB），未设置时返回 def，格式错误时退出
+func envSize(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := parseSize(v)
+	if err != nil {
+		fatal("invalid "+key, "value", v, "error", err)
+	}
+	return n
+}
+
+// envHeader 读取响应头的值，未设置时返回 def，设置为 off 时返回空字符串表示不设置该响应头
+func envHeader(key string, def string) string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	if strings.EqualFold(v, "off") {
+		return ""
+	}
+	return v
+}
+
+// normalizeType 统一图片类型的写法，例如 JPEG 转换为 jpg
+func normalizeType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	switch t {
+	case "jpeg":
+		return "jpg"
+	case "heic":
+		return "heif"
+	}
+	return t
+}
+
+// sizeUnits parseSize 支持的单位
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize 解析 25MB、512KB、1048576 这样的大小，单位不区分大小写，按 1024 换算
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("must be a number of bytes or end with KB, MB or GB")
+	}
+	if n <= 0 || n > math.MaxInt64/unit {
+		return 0, errors.New("out of range")
+	}
+	return n * unit, nil
+}
+
+// formatSize 把字节数格式化为 10MB、512KB 这样的大小
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
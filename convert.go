@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// convertSources 可以通过 /convert 转换格式的原图格式，动图只保留第一帧
+var convertSources = map[string]bool{
+	"jpg":  true,
+	"png":  true,
+	"bmp":  true,
+	"gif":  true,
+	"webp": true,
+	"avif": true,
+}
+
+// convertHandler 把已上传的图片转换为 ?to= 指定的格式（jpg、png、webp 或 avif）后返回，
+// 结果与缩放后的图片一样缓存在 ResizeCacheDir。动图转换后只有第一帧，这时响应头带有 Warning
+func convertHandler(context *gin.Context) {
+	name := strings.TrimPrefix(context.Param("path"), "/")
+	to := normalizeType(context.Query("to"))
+	if !variantFormats[to] {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "to 只能是 jpg、png、webp 或 avif！"})
+		return
+	}
+	ext := normalizeType(strings.TrimPrefix(path.Ext(name), "."))
+	if !convertSources[ext] {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "该文件不支持转换格式！"})
+		return
+	}
+	if !checkImageAccess(context, name) {
+		return
+	}
+
+	if src, err := (&LocalBackend{Root: staticDir}).resolve(name); err == nil {
+		animated, err := isAnimated(src, ext)
+		if err != nil {
+			slog.WarnContext(context, "failed to check whether the image is animated", "path", name, "error", err)
+		}
+		if animated {
+			context.Header("Warning", `199 - "animated image converted to a static format, only the first frame is kept"`)
+		}
+	}
+	sendVariant(context, name, ext, &variantOptions{fit: FitContain, format: to})
+}
+
+// isAnimated 文件 src 是否是有多帧的 GIF 或者带有动画标记的 WebP，其他格式返回 false
+func isAnimated(src string, ext string) (bool, error) {
+	if ext != "gif" && ext != "webp" {
+		return false, nil
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	if ext == "webp" {
+		// 扩展格式的 WebP 以 VP8X 块开头，其中的 0x02 标记表示动画
+		head := make([]byte, 21)
+		_, err = io.ReadFull(file, head)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return err == nil && string(head[12:16]) == "VP8X" && head[20]&0x02 != 0, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return false, err
+	}
+	return gifFrames(data) > 1, nil
+}
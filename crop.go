@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"image"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// errCropOutOfBounds 裁剪区域与图片没有重叠
+var errCropOutOfBounds = errors.New("裁剪区域超出了图片范围！")
+
+// cropGravities 按宽高比裁剪时可以通过 ?gravity= 指定的保留位置
+var cropGravities = map[string]imaging.Anchor{
+	"center":       imaging.Center,
+	"top":          imaging.Top,
+	"bottom":       imaging.Bottom,
+	"left":         imaging.Left,
+	"right":        imaging.Right,
+	"top-left":     imaging.TopLeft,
+	"top-right":    imaging.TopRight,
+	"bottom-left":  imaging.BottomLeft,
+	"bottom-right": imaging.BottomRight,
+}
+
+// cropHandler 返回 ./static 下图片按 ?x=&y=&w=&h= 裁剪后的版本，或者按 ?ar=16:9&gravity=center
+// 裁剪出指定宽高比的最大区域。部分超出图片的区域会被截断，完全超出时返回 400，
+// 结果与缩放后的图片一样缓存在 ResizeCacheDir，并共用 RESIZE_CONCURRENCY 的解码数量限制
+func cropHandler(context *gin.Context) {
+	name := strings.TrimPrefix(context.Param("path"), "/")
+	opts, err := parseCropOptions(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ext := normalizeType(strings.TrimPrefix(path.Ext(name), "."))
+	if !variantSources[ext] {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "该文件不支持裁剪！"})
+		return
+	}
+	if !checkImageAccess(context, name) {
+		return
+	}
+	sendVariant(context, name, ext, opts)
+}
+
+// parseCropOptions 读取裁剪参数，x、y、w、h 必须同时指定，没有指定时必须指定 ar
+func parseCropOptions(context *gin.Context) (*variantOptions, error) {
+	opts := &variantOptions{fit: FitContain}
+	if context.Query("x") != "" || context.Query("y") != "" || context.Query("w") != "" || context.Query("h") != "" {
+		var rect [4]int
+		for i, key := range []string{"x", "y", "w", "h"} {
+			n, err := strconv.Atoi(context.Query(key))
+			if err != nil || n < 0 || (i >= 2 && n < 1) {
+				return nil, errors.New("x、y 必须是非负整数，w、h 必须是正整数！")
+			}
+			rect[i] = n
+		}
+		opts.crop = image.Rect(rect[0], rect[1], rect[0]+rect[2], rect[1]+rect[3])
+		return opts, nil
+	}
+
+	ar := context.Query("ar")
+	if ar == "" {
+		return nil, errors.New("请指定裁剪区域 x、y、w、h 或者宽高比 ar！")
+	}
+	w, h, found := strings.Cut(ar, ":")
+	aspectW, errW := strconv.Atoi(w)
+	aspectH, errH := strconv.Atoi(h)
+	if !found || errW != nil || errH != nil || aspectW < 1 || aspectH < 1 || aspectW > maxVariantSize || aspectH > maxVariantSize {
+		return nil, errors.New("ar 必须是 16:9 这样的宽高比！")
+	}
+	opts.aspect = image.Pt(aspectW, aspectH)
+	opts.gravity = context.DefaultQuery("gravity", "center")
+	if _, ok := cropGravities[opts.gravity]; !ok {
+		return nil, errors.New("gravity 只能是 center、top、bottom、left、right、top-left、top-right、bottom-left 或 bottom-right！")
+	}
+	return opts, nil
+}
+
+// cropImage 按 opts 中的裁剪区域或者宽高比裁剪图片，没有指定时原样返回
+func cropImage(img image.Image, opts *variantOptions) (image.Image, error) {
+	bounds := img.Bounds()
+	switch {
+	case !opts.crop.Empty():
+		rect := opts.crop.Add(bounds.Min).Intersect(bounds)
+		if rect.Empty() {
+			return nil, errCropOutOfBounds
+		}
+		return imaging.Crop(img, rect), nil
+	case opts.aspect != image.Point{}:
+		// 在不超出图片的前提下取指定宽高比的最大区域
+		width := bounds.Dx()
+		height := width * opts.aspect.Y / opts.aspect.X
+		if height > bounds.Dy() {
+			height = bounds.Dy()
+			width = height * opts.aspect.X / opts.aspect.Y
+		}
+		return imaging.CropAnchor(img, max(1, width), max(1, height), cropGravities[opts.gravity]), nil
+	}
+	return img, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// db 保存图片统计等数据的 SQLite 数据库
+var db *sql.DB
+
+// migrations 按顺序执行的建表语句，已经执行过的不会重复执行
+var migrations = []string{
+	`CREATE TABLE image_stats (
+		path TEXT PRIMARY KEY,
+		upload_time INTEGER,
+		download_count INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE images (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sha256 TEXT NOT NULL,
+		original_filename TEXT NOT NULL,
+		stored_path TEXT NOT NULL UNIQUE,
+		mime_type TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		width INTEGER,
+		height INTEGER,
+		uploaded_at INTEGER NOT NULL,
+		uploader_ip TEXT NOT NULL
+	)`,
+	`CREATE INDEX images_sha256 ON images (sha256)`,
+	`CREATE INDEX images_uploaded_at ON images (uploaded_at)`,
+	`CREATE TABLE albums (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE album_images (
+		album_id INTEGER NOT NULL REFERENCES albums (id) ON DELETE CASCADE,
+		image_id INTEGER NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+		added_at INTEGER NOT NULL,
+		PRIMARY KEY (album_id, image_id)
+	)`,
+	`CREATE INDEX album_images_image_id ON album_images (image_id)`,
+	`CREATE TABLE image_expirations (
+		path TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL,
+		removed_at INTEGER
+	)`,
+	`CREATE INDEX image_expirations_expires_at ON image_expirations (expires_at)`,
+	`ALTER TABLE images ADD COLUMN blurhash TEXT`,
+	`ALTER TABLE images ADD COLUMN dominant_color TEXT`,
+	`ALTER TABLE images ADD COLUMN phash INTEGER`,
+	`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`,
+	`ALTER TABLE images ADD COLUMN uploaded_by_user_id INTEGER REFERENCES users (id)`,
+	`CREATE INDEX images_uploaded_by_user_id ON images (uploaded_by_user_id)`,
+	`ALTER TABLE images ADD COLUMN original_path TEXT`,
+}
+
+// openDB 打开 path 处的数据库并执行未执行过的 migrations
+func openDB(path string) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 同一时间只允许一个写入
+	conn.SetMaxOpenConns(1)
+
+	err = migrate(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// migrate 使用 user_version 记录已经执行的 migrations 数量
+func migrate(conn *sql.DB) error {
+	var version int
+	err := conn.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := conn.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(migrations[i])
+		if err == nil {
+			// PRAGMA 不支持参数
+			_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(i+1))
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
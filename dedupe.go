@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// hashLocks 相同内容的上传串行处理，避免并发上传同一个新文件时保存两份
+var hashLocks = newKeyedMutex()
+
+// keyedMutex 按 key 加锁，不同 key 之间互不影响
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// Lock 对 key 加锁，返回解锁函数
+func (m *keyedMutex) Lock(key string) func() {
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		m.locks[key] = lock
+	}
+	lock.refs++
+	m.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		m.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// sha256Hex 计算内容的 SHA-256
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashIndexPath 旧版本索引文件的路径，IndexDir/<前两位>/<sha256>，内容为已保存文件的路径
+func hashIndexPath(sum string) string {
+	return filepath.Join(IndexDir, sum[:2], sum)
+}
+
+// lookupDuplicate 查找内容相同的已保存文件，文件已被删除时视为不存在。
+// 先查询数据库，找不到时再查询旧版本写入 IndexDir 的索引
+func lookupDuplicate(sum string) (string, bool, error) {
+	path, err := findImageBySHA256(sum)
+	if err != nil {
+		return "", false, err
+	}
+	if path == "" {
+		data, err := os.ReadFile(hashIndexPath(sum))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		path = strings.TrimSpace(string(data))
+	}
+
+	exists, err := Storage.Exists(path)
+	if err != nil {
+		return "", false, err
+	}
+	return path, exists, nil
+}
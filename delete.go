@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// deleteHandler 删除 /upload/:year/:month/:day/:filename 对应的图片
+func deleteHandler(context *gin.Context) {
+	fileName := context.Param("filename")
+	if fileName == "." || fileName == ".." || strings.ContainsAny(fileName, "/\\") {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "文件名无效！"})
+		return
+	}
+
+	dir := make([]string, 0, 3)
+	for _, key := range []string{"year", "month", "day"} {
+		n, err := strconv.Atoi(context.Param(key))
+		if err != nil || n <= 0 {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "日期无效！"})
+			return
+		}
+		dir = append(dir, strconv.Itoa(n))
+	}
+	dst := strings.Join(dir, "/") + "/" + fileName
+
+	exists, err := Storage.Exists(dst)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return
+	}
+	// 通过 JWT 登录的用户只能删除自己上传的图片，使用 API_KEY 时可以删除任何图片
+	if userID := requestUserID(context); userID != nil {
+		record, err := findImage(dst)
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if record == nil || record.UploadedBy == nil || *record.UploadedBy != *userID {
+			context.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己上传的图片！"})
+			return
+		}
+	}
+
+	err = removeImage(dst)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"message": "图片删除成功！"})
+}
+
+// removeImage 删除图片 dst 以及它的缩略图、HEIC 或 GIF 原图、缓存的缩放版本和上传记录
+func removeImage(dst string) error {
+	record, err := findImage(dst)
+	if err != nil {
+		return err
+	}
+	err = Storage.Delete(dst)
+	if err != nil {
+		return err
+	}
+	// 缩略图可能没有生成，忽略删除失败
+	_ = Storage.Delete(thumbnailPath(dst))
+	// 由 HEIC 或动图 GIF 转换而来的文件可能同时保存了原图，只删除上传记录中的原图，
+	// 不能按扩展名猜测，同名的 .heic 或 .gif 可能是另外上传的图片
+	if record != nil && record.OriginalPath != "" {
+		_ = Storage.Delete(record.OriginalPath)
+	}
+	purgeVariants(dst)
+	if err := deleteImage(dst); err != nil {
+		slog.Error("failed to delete image record", "path", dst, "error", err)
+	}
+	return nil
+}
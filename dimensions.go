@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+)
+
+// needsDimensionCheck 是否配置了图片宽高或像素数的上限
+func needsDimensionCheck() bool {
+	return MaxWidth > 0 || MaxHeight > 0 || MaxPixels > 0
+}
+
+// checkDimensions 只解码图片头部检查宽高和像素数，超过上限时返回 400。
+// 返回的 Reader 包含已经读取的头部，可以代替 r 继续读取完整内容。
+// 无法识别尺寸的格式在 StrictDimensions 为 true 时拒绝，否则放行
+func checkDimensions(r io.Reader) (io.Reader, *uploadError) {
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	r = io.MultiReader(&header, r)
+	if err != nil {
+		if StrictDimensions {
+			return nil, &uploadError{http.StatusBadRequest, "无法识别图片尺寸！"}
+		}
+		return r, nil
+	}
+
+	if (MaxWidth > 0 && config.Width > MaxWidth) || (MaxHeight > 0 && config.Height > MaxHeight) {
+		return nil, &uploadError{http.StatusBadRequest,
+			fmt.Sprintf("图片尺寸 %dx%d 超过限制！最大宽度 %s，最大高度 %s", config.Width, config.Height, limitString(MaxWidth), limitString(MaxHeight))}
+	}
+	if pixels := int64(config.Width) * int64(config.Height); MaxPixels > 0 && pixels > MaxPixels {
+		return nil, &uploadError{http.StatusBadRequest,
+			fmt.Sprintf("图片尺寸 %dx%d 共 %d 像素，超过限制 %d 像素！", config.Width, config.Height, pixels, MaxPixels)}
+	}
+	return r, nil
+}
+
+// errTooManyPixels 图片头部声明的像素数超过 MaxPixels，完整解码可能耗尽内存
+var errTooManyPixels = errors.New("图片像素数超过限制！")
+
+// checkDecodePixels 只读取 r 中的图片头部，声明的像素数超过 MaxPixels 时返回 errTooManyPixels。
+// 返回的 Reader 包含已经读取的头部，可以代替 r 继续读取完整内容，无法识别尺寸时交给解码器处理
+func checkDecodePixels(r io.Reader) (io.Reader, error) {
+	if MaxPixels <= 0 {
+		return r, nil
+	}
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err == nil && int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, errTooManyPixels
+	}
+	return io.MultiReader(&header, r), nil
+}
+
+// decodeImage 检查头部中的像素数后再完整解码 r 中的图片。只有几百字节却声明了巨大宽高的图片在解码时
+// 会分配几 GB 内存，所有完整解码图片的地方都需要先经过这一步
+func decodeImage(r io.Reader) (image.Image, string, error) {
+	r, err := checkDecodePixels(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return image.Decode(r)
+}
+
+// limitString 上限为 0 时显示为不限制
+func limitString(limit int) string {
+	if limit <= 0 {
+		return "不限"
+	}
+	return fmt.Sprint(limit)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// memoryCeiling 拒绝声明了巨大宽高的图片时允许分配的内存上限，完整解码 4000x4000 的图片需要 64 MiB
+const memoryCeiling = 16 << 20
+
+// craftedPNG 返回只有几十字节、IHDR 中却声明了 width x height 的 PNG
+func craftedPNG(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := func(kind string, data []byte) {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(kind))
+		crc.Write(data)
+		buf.WriteString(kind)
+		buf.Write(data)
+		_ = binary.Write(&buf, binary.BigEndian, crc.Sum32())
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8 位 RGBA
+	chunk("IHDR", ihdr)
+	chunk("IDAT", []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01})
+	chunk("IEND", nil)
+	return buf.Bytes()
+}
+
+// allocated 返回 f 执行期间分配的内存总量
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// withMaxPixels 在测试期间把 MaxPixels 设置为 n
+func withMaxPixels(t *testing.T, n int64) {
+	old := MaxPixels
+	MaxPixels = n
+	t.Cleanup(func() { MaxPixels = old })
+}
+
+func TestDecodeImageRejectsHugeDimensions(t *testing.T) {
+	withMaxPixels(t, 1_000_000)
+	data := craftedPNG(4000, 4000)
+
+	var err error
+	n := allocated(func() {
+		_, _, err = decodeImage(bytes.NewReader(data))
+	})
+	if !errors.Is(err, errTooManyPixels) {
+		t.Fatalf("decodeImage error = %v, want errTooManyPixels", err)
+	}
+	if n > memoryCeiling {
+		t.Errorf("decodeImage allocated %d bytes, want at most %d", n, memoryCeiling)
+	}
+}
+
+func TestSaveImageRejectsHugeDimensions(t *testing.T) {
+	root := useTestStorage(t)
+	withMaxPixels(t, 1_000_000)
+	data := craftedPNG(4000, 4000)
+
+	var uploadErr *uploadError
+	n := allocated(func() {
+		_, uploadErr = saveImage(ctx.Background(), "bomb.png", bytes.NewReader(data), int64(len(data)), uploadOptions{clientIP: "127.0.0.1"})
+	})
+	if uploadErr == nil || uploadErr.Status != http.StatusBadRequest {
+		t.Fatalf("saveImage error = %v, want status 400", uploadErr)
+	}
+	if n > memoryCeiling {
+		t.Errorf("saveImage allocated %d bytes, want at most %d", n, memoryCeiling)
+	}
+	matches, _ := filepath.Glob(filepath.Join(root, "*", "*", "*", "bomb*"))
+	if len(matches) != 0 {
+		t.Errorf("rejected image was stored: %v", matches)
+	}
+	if _, err := os.Stat(filepath.Join(root, "thumbs")); err == nil {
+		t.Error("thumbnail directory created for a rejected image")
+	}
+}
+
+// craftedGIF 返回画面为 width x height、包含 frames 个 1x1 帧的 GIF，文件只有几 KB
+func craftedGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	g := &gif.GIF{Config: image.Config{Width: width, Height: height, ColorModel: color.Palette(palette.Plan9)}}
+	for range frames {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFToWebPRejectsHugeCanvasFrames(t *testing.T) {
+	withMaxPixels(t, 50_000_000)
+	data := craftedGIF(t, 4000, 4000, 1000)
+
+	var err error
+	n := allocated(func() {
+		_, err = gifToWebP(ctx.Background(), data)
+	})
+	if !errors.Is(err, errTooManyPixels) {
+		t.Fatalf("gifToWebP error = %v, want errTooManyPixels", err)
+	}
+	// 解码 1000 个帧本身约需 20 MiB，全部合成为 4000x4000 的画面则需要 64 GB
+	if n > 4*memoryCeiling {
+		t.Errorf("gifToWebP of a %d byte GIF allocated %d bytes, want at most %d", len(data), n, 4*memoryCeiling)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// diskCritical 存储目录所在磁盘剩余空间低于 DiskCriticalThreshold 时为 true
+var diskCritical atomic.Bool
+
+// monitorDiskSpace 每隔 DiskCheckInterval 检查本地存储目录所在磁盘的剩余空间，远程存储后端不检查
+func monitorDiskSpace() {
+	local, ok := Storage.(*LocalBackend)
+	if !ok {
+		return
+	}
+	checkDiskSpace(local.Root)
+	for range time.Tick(DiskCheckInterval) {
+		checkDiskSpace(local.Root)
+	}
+}
+
+// checkDiskSpace 剩余空间低于 DiskAlertThreshold 时记录 ERROR 日志，并按 DiskCriticalThreshold 更新 diskCritical
+func checkDiskSpace(root string) {
+	free, err := diskFree(root)
+	if err != nil {
+		slog.Warn("failed to get free disk space", "path", root, "error", err)
+		return
+	}
+	critical := free < DiskCriticalThreshold
+	if diskCritical.Swap(critical) && !critical {
+		slog.Info("free disk space recovered, accepting uploads again", "path", root, "free_bytes", free)
+	}
+	switch {
+	case critical:
+		slog.Error("free disk space is critically low, rejecting uploads", "path", root, "free_bytes", free, "critical_bytes", DiskCriticalThreshold)
+	case free < DiskAlertThreshold:
+		slog.Error("free disk space is low", "path", root, "free_bytes", free, "threshold_bytes", DiskAlertThreshold)
+	}
+}
+
+// rejectWhenDiskFull 磁盘剩余空间不足时上传请求直接返回 507，不再读取请求体，删除图片可以释放空间所以不受影响
+func rejectWhenDiskFull(context *gin.Context) {
+	if diskCritical.Load() && context.Request.Method != http.MethodDelete {
+		context.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": "存储空间不足，暂时无法上传！"})
+		return
+	}
+	context.Next()
+}
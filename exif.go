@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"regexp"
+)
+
+// 上传图片中元数据的处理方式
+const (
+	// EXIFModeStrip 删除 EXIF、XMP、IPTC 等全部元数据
+	EXIFModeStrip = "strip"
+	// EXIFModeStripGPS 只删除 EXIF 中的 GPS IFD 和 XMP 中的 GPS 标签，保留版权、相机等信息
+	EXIFModeStripGPS = "strip-gps"
+	// EXIFModeKeep 保留元数据
+	EXIFModeKeep = "keep"
+)
+
+// stripMetadata 删除 ext 格式图片中的 EXIF、XMP、IPTC 等元数据，只删除对应的段或块，不重新编码，
+// 图像数据保持不变。返回处理后的内容以及是否删除了元数据，不支持的格式或者无法解析时原样返回
+func stripMetadata(data []byte, ext string) ([]byte, bool) {
+	switch ext {
+	case "jpg":
+		return stripJPEGMetadata(data)
+	case "png":
+		return stripPNGMetadata(data)
+	case "webp":
+		return stripWebPMetadata(data)
+	}
+	return data, false
+}
+
+// stripJPEGMetadata 删除 JPEG 中所有的 APP1（EXIF、XMP）和 APP13（Photoshop IPTC）段，
+// 保留 APP2 中的 ICC 颜色配置
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	stripped := false
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return data, false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// 段之间的填充字节
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// 从 SOS 开始是压缩后的图像数据，不再解析
+			return append(out, data[i:]...), stripped
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// 没有长度字段的标记
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return data, false
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) {
+			return data, false
+		}
+		if marker == 0xE1 || marker == 0xED {
+			stripped = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// pngSignature PNG 文件头
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks 会被删除的 PNG 块，文本块中可能包含 XMP
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNGMetadata 删除 PNG 中的 EXIF 和文本块，其他块原样保留
+func stripPNGMetadata(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	stripped := false
+	i := len(pngSignature)
+	for i < len(data) {
+		// 长度（4 字节）+ 类型（4 字节）+ 数据 + CRC（4 字节）
+		if i+8 > len(data) {
+			return data, false
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:i+4]))
+		if end > len(data) || end < i {
+			return data, false
+		}
+		if pngMetadataChunks[string(data[i+4:i+8])] {
+			stripped = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, stripped
+}
+
+// WebP 扩展格式 VP8X 块中表示包含 EXIF 和 XMP 的标志位
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebPMetadata 删除 WebP 中的 EXIF 和 XMP 块，并清除 VP8X 中对应的标志位
+func stripWebPMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	stripped := false
+	vp8x := -1
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return data, false
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		// 块的数据长度为奇数时后面有一个填充字节
+		end := i + 8 + size + size%2
+		if end > len(data) || end < i {
+			return data, false
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			stripped = true
+		case "VP8X":
+			vp8x = len(out) + 8
+			out = append(out, data[i:end]...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !stripped {
+		return data, false
+	}
+	if vp8x >= 0 && vp8x < len(out) {
+		out[vp8x] &^= webpFlagEXIF | webpFlagXMP
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, true
+}
+
+// gpsIFDTag IFD0 中指向 GPS IFD 的标签
+const gpsIFDTag = 0x8825
+
+// xmpHeader APP1 段中 XMP 数据的标识
+var xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// tiffTypeSizes TIFF 各数据类型每个值的字节数
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// xmpGPSPatterns XMP 中 exif:GPS 开头的标签，依次匹配属性、自闭合元素和成对的元素
+var xmpGPSPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\s+exif:GPS[A-Za-z]+\s*=\s*("[^"]*"|'[^']*')`),
+	regexp.MustCompile(`<exif:GPS[A-Za-z]+(\s[^>]*)?/>`),
+	regexp.MustCompile(`(?s)<exif:GPS[A-Za-z]+(\s[^>]*)?>.*?</exif:GPS[A-Za-z]+>`),
+}
+
+// stripGPS 只删除 ext 格式图片中的位置信息：JPEG、PNG、WebP 中 EXIF 的 GPS IFD，以及 JPEG 中 XMP 的 GPS 标签，
+// 其它元数据保留。EXIF 在原来的位置改写，长度和偏移都不变，图像数据不重新编码。
+// 返回处理后的内容以及是否删除了位置信息，不支持的格式或者无法解析时原样返回
+func stripGPS(data []byte, ext string) ([]byte, bool) {
+	switch ext {
+	case "jpg":
+		return stripJPEGGPS(data)
+	case "png":
+		return stripPNGGPS(data)
+	case "webp":
+		return stripWebPGPS(data)
+	}
+	return data, false
+}
+
+// stripJPEGGPS 删除 JPEG 的 APP1 段中 EXIF 的 GPS IFD 和 XMP 中的 GPS 标签
+func stripJPEGGPS(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	stripped := false
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return data, false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			return append(out, data[i:]...), stripped
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return data, false
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) || end < i+4 {
+			return data, false
+		}
+		segment := bytes.Clone(data[i:end])
+		if marker == 0xE1 {
+			payload := segment[4:]
+			switch {
+			case bytes.HasPrefix(payload, exifHeader):
+				stripped = removeGPSIFD(payload[len(exifHeader):]) || stripped
+			case bytes.HasPrefix(payload, xmpHeader):
+				xmp := payload[len(xmpHeader):]
+				cleaned := xmp
+				for _, pattern := range xmpGPSPatterns {
+					cleaned = pattern.ReplaceAll(cleaned, nil)
+				}
+				if len(cleaned) != len(xmp) {
+					stripped = true
+					segment = append(segment[:4+len(xmpHeader)], cleaned...)
+					binary.BigEndian.PutUint16(segment[2:4], uint16(len(segment)-2))
+				}
+			}
+		}
+		out = append(out, segment...)
+		i = end
+	}
+}
+
+// stripPNGGPS 删除 PNG eXIf 块中的 GPS IFD 并重新计算块的 CRC
+func stripPNGGPS(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data, false
+	}
+
+	out := bytes.Clone(data)
+	stripped := false
+	i := len(pngSignature)
+	for i < len(out) {
+		if i+8 > len(out) {
+			return data, false
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(out[i:i+4]))
+		if end > len(out) || end < i {
+			return data, false
+		}
+		if string(out[i+4:i+8]) == "eXIf" && removeGPSIFD(out[i+8:end-4]) {
+			stripped = true
+			binary.BigEndian.PutUint32(out[end-4:end], crc32.ChecksumIEEE(out[i+4:end-4]))
+		}
+		i = end
+	}
+	if !stripped {
+		return data, false
+	}
+	return out, true
+}
+
+// stripWebPGPS 删除 WebP EXIF 块中的 GPS IFD
+func stripWebPGPS(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data, false
+	}
+
+	out := bytes.Clone(data)
+	stripped := false
+	i := 12
+	for i < len(out) {
+		if i+8 > len(out) {
+			return data, false
+		}
+		size := int(binary.LittleEndian.Uint32(out[i+4 : i+8]))
+		end := i + 8 + size + size%2
+		if end > len(out) || end < i {
+			return data, false
+		}
+		if string(out[i:i+4]) == "EXIF" {
+			// 部分软件写入的 EXIF 块仍然带有 Exif\0\0 前缀
+			tiff := out[i+8 : i+8+size]
+			if bytes.HasPrefix(tiff, exifHeader) {
+				tiff = tiff[len(exifHeader):]
+			}
+			stripped = removeGPSIFD(tiff) || stripped
+		}
+		i = end
+	}
+	if !stripped {
+		return data, false
+	}
+	return out, true
+}
+
+// removeGPSIFD 在 TIFF 格式的 EXIF 数据 tiff 中原地删除 IFD0 里指向 GPS IFD 的条目，后面的条目前移，
+// 并把 GPS IFD 以及它引用的数据清零，总长度和其它数据的偏移不变。没有 GPS IFD 或者无法解析时返回 false
+func removeGPSIFD(tiff []byte) bool {
+	if len(tiff) < 8 {
+		return false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false
+	}
+
+	ifd0 := int(order.Uint32(tiff[4:8]))
+	if ifd0 < 8 || ifd0+2 > len(tiff) {
+		return false
+	}
+	count := int(order.Uint16(tiff[ifd0 : ifd0+2]))
+	// 条目之后是 4 字节的下一个 IFD 的位置
+	ifdEnd := ifd0 + 2 + 12*count + 4
+	if ifdEnd > len(tiff) {
+		return false
+	}
+	for k := 0; k < count; k++ {
+		entry := ifd0 + 2 + 12*k
+		if order.Uint16(tiff[entry:entry+2]) != gpsIFDTag {
+			continue
+		}
+		clearIFD(tiff, order, int(order.Uint32(tiff[entry+8:entry+12])))
+		copy(tiff[entry:], tiff[entry+12:ifdEnd])
+		clear(tiff[ifdEnd-12 : ifdEnd])
+		order.PutUint16(tiff[ifd0:ifd0+2], uint16(count-1))
+		return true
+	}
+	return false
+}
+
+// clearIFD 把 offset 处的 IFD 以及其中条目引用的数据清零，超出范围的部分忽略
+func clearIFD(tiff []byte, order binary.ByteOrder, offset int) {
+	if offset < 8 || offset+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	end := min(offset+2+12*count+4, len(tiff))
+	for k := 0; k < count; k++ {
+		entry := offset + 2 + 12*k
+		if entry+12 > len(tiff) {
+			break
+		}
+		size := tiffTypeSizes[order.Uint16(tiff[entry+2:entry+4])] * int(order.Uint32(tiff[entry+4:entry+8]))
+		if size <= 4 {
+			continue
+		}
+		// 超过 4 字节的值保存在条目之外，条目中是值的位置
+		start := int(order.Uint32(tiff[entry+8 : entry+12]))
+		if start >= 8 && start < len(tiff) && size <= len(tiff)-start {
+			clear(tiff[start : start+size])
+		}
+	}
+	clear(tiff[offset:end])
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errInvalidExpires expires 参数格式错误或者不是将来的时间
+var errInvalidExpires = errors.New("expires 无效！支持 30m、1h、7d 这样的时长或者 RFC3339 格式的将来时间")
+
+// parseExpires 解析上传时的 expires 参数，支持 time.ParseDuration 的格式、以 d 结尾的天数以及 RFC3339 时间
+func parseExpires(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	var expires time.Time
+	if days, found := strings.CutSuffix(v, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, errInvalidExpires
+		}
+		expires = now.AddDate(0, 0, n)
+	} else if d, err := time.ParseDuration(v); err == nil {
+		expires = now.Add(d)
+	} else if expires, err = time.Parse(time.RFC3339, v); err != nil {
+		return time.Time{}, errInvalidExpires
+	}
+	if !expires.After(now) {
+		return time.Time{}, errInvalidExpires
+	}
+	return expires.Truncate(time.Second), nil
+}
+
+// setExpiry 设置图片 path 的过期时间
+func setExpiry(path string, expires time.Time) error {
+	_, err := db.Exec(`INSERT INTO image_expirations (path, expires_at) VALUES (?, ?)
+		ON CONFLICT (path) DO UPDATE SET expires_at = excluded.expires_at, removed_at = NULL`, path, expires.Unix())
+	return err
+}
+
+// clearExpiry 删除 path 的过期记录，同一路径重新上传图片时调用
+func clearExpiry(path string) error {
+	_, err := db.Exec(`DELETE FROM image_expirations WHERE path = ?`, path)
+	return err
+}
+
+// isExpired 图片 path 是否已经过期，过期后即使还没有被删除也不再提供访问
+func isExpired(path string) (bool, error) {
+	var expiresAt int64
+	err := db.QueryRow(`SELECT expires_at FROM image_expirations WHERE path = ?`, path).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return expiresAt <= time.Now().Unix(), nil
+}
+
+// cleanupExpiredImages 每分钟删除已经过期的图片，删除后保留过期记录，访问时返回 410
+func cleanupExpiredImages() {
+	for range time.Tick(time.Minute) {
+		removeExpiredImages()
+	}
+}
+
+func removeExpiredImages() {
+	rows, err := db.Query(`SELECT path FROM image_expirations WHERE expires_at <= ? AND removed_at IS NULL`, time.Now().Unix())
+	if err != nil {
+		slog.Error("failed to query expired images", "error", err)
+		return
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	_ = rows.Close()
+
+	for _, path := range paths {
+		slog.Info("removing expired image", "path", path)
+		// 文件可能已经被手动删除，删除失败时仍然标记为已删除，避免每分钟重试
+		if err := removeImage(path); err != nil {
+			slog.Warn("failed to remove expired image", "path", path, "error", err)
+		}
+		_, err := db.Exec(`UPDATE image_expirations SET removed_at = ? WHERE path = ?`, time.Now().Unix(), path)
+		if err != nil {
+			slog.Error("failed to mark expired image as removed", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"time"
+)
+
+// feedSize RSS 中最近上传的图片数量
+const feedSize = 20
+
+// rssFeed RSS 2.0 文档
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      string       `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// feedHandler 以 RSS 2.0 格式返回最近上传的 feedSize 张图片，标题为 FEED_TITLE，链接为 URL
+func feedHandler(context *gin.Context) {
+	records, _, err := listImages(1, feedSize, time.Time{}, nil)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         FeedTitle,
+			Link:          Url,
+			Description:   FeedTitle + " 最近上传的图片",
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(records)),
+		},
+	}
+	for _, record := range records {
+		url := Storage.URL(record.StoredPath)
+		title := record.OriginalFilename
+		if title == "" {
+			title = path.Base(record.StoredPath)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:     title,
+			Link:      url,
+			GUID:      url,
+			PubDate:   record.UploadedAt.Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{URL: url, Length: record.SizeBytes, Type: record.MimeType},
+		})
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.Data(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// templatePlaceholders 文件名模板支持的占位符
+var templatePlaceholders = map[string]string{
+	"year":     "年份，例如 2023",
+	"month":    "月份，不补零，例如 9",
+	"day":      "日期，不补零，例如 1",
+	"hour":     "小时，不补零，例如 8",
+	"unix":     "Unix 时间戳（秒）",
+	"uuid":     "随机 UUIDv4",
+	"hash":     "内容 SHA-256 的前 16 位",
+	"hash8":    "内容 SHA-256 的前 8 位",
+	"original": "原始文件名",
+	"filename": "原始文件名，与 {original} 相同",
+	"ext":      "根据文件内容判断的扩展名",
+}
+
+// namingTemplates NAMING 对应的默认文件名模板
+var namingTemplates = map[string]string{
+	NamingOriginal: "{year}/{month}/{day}/{original}",
+	NamingHash:     "{year}/{month}/{day}/{hash}.{ext}",
+	NamingUUID:     "{year}/{month}/{day}/{uuid}.{ext}",
+}
+
+// placeholderList 返回按名称排序的占位符及其说明，用于启动日志
+func placeholderList() []string {
+	list := make([]string, 0, len(templatePlaceholders))
+	for name, description := range templatePlaceholders {
+		list = append(list, "{"+name+"} "+description)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// errInvalidFilename 渲染出的路径包含空的、. 或 .. 的路径段
+var errInvalidFilename = errors.New("文件名无效！")
+
+// windowsReservedNames Windows 下不能作为文件名的设备名
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFilename 清理客户端提供的文件名：去掉目录部分（/ 和 \ 都视为分隔符）和控制字符，
+// 去掉首尾空格以及结尾的 .，清理后为空、. 、.. 或 Windows 保留设备名时返回空字符串
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+
+	if name == "" || name == "/" {
+		return ""
+	}
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		return ""
+	}
+	return name
+}
+
+// pathTemplate 文件保存路径模板，例如 {year}/{month}/{day}/{original}
+type pathTemplate struct {
+	source string
+	// parts 按顺序排列的片段，placeholder 为空时表示固定文本 literal
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal     string
+	placeholder string
+}
+
+// parsePathTemplate 解析路径模板，未知占位符、绝对路径以及包含空、. 或 .. 路径段的模板会返回错误
+func parsePathTemplate(s string) (*pathTemplate, error) {
+	t := &pathTemplate{source: s}
+	rest := s
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", s)
+		}
+		name := rest[start+1 : start+end]
+		if _, ok := templatePlaceholders[name]; !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in %q", name, s)
+		}
+		t.parts = append(t.parts, templatePart{placeholder: name})
+		rest = rest[start+end+1:]
+	}
+
+	for _, part := range t.parts {
+		if strings.ContainsAny(part.literal, "}\\") {
+			return nil, fmt.Errorf("invalid character in %q", s)
+		}
+	}
+	if strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("template must be a relative path: %q", s)
+	}
+	for _, segment := range strings.Split(s, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("template contains an invalid path segment: %q", s)
+		}
+	}
+	return t, nil
+}
+
+// Uses 模板中是否使用了占位符 name
+func (t *pathTemplate) Uses(name string) bool {
+	for _, part := range t.parts {
+		if part.placeholder == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Render 使用 values 替换占位符。占位符的值不能包含路径分隔符，替换后的每个路径段都不能为空、. 或 ..
+func (t *pathTemplate) Render(values map[string]string) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.placeholder == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value := values[part.placeholder]
+		if strings.ContainsAny(value, "/\\") {
+			return "", errInvalidFilename
+		}
+		b.WriteString(value)
+	}
+
+	path := b.String()
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", errInvalidFilename
+		}
+	}
+	return path, nil
+}
+
+// UsesHash 模板中是否使用了依赖内容 SHA-256 的占位符
+func (t *pathTemplate) UsesHash() bool {
+	return t.Uses("hash") || t.Uses("hash8")
+}
+
+// String 返回模板原文
+func (t *pathTemplate) String() string {
+	return t.source
+}
+
+// maxRenameAttempts 文件名冲突时最多尝试的次数
+const maxRenameAttempts = 100
+
+// pathLocks 按保存路径加锁，同名文件同时上传时只有一个能使用该路径
+var pathLocks = newKeyedMutex()
+
+// renderFilename 按 FilenameTemplate 生成保存路径。original 为原始文件名，ext 为扩展名，
+// sum 为内容的 SHA-256（模板没有使用 {hash} 或 {hash8} 时可以为空）。
+// 路径已经存在时重新生成 {uuid}，或者在原始文件名（模板没有使用 {original} 或 {filename} 时为路径末尾）后加上 6 位随机后缀。
+// 返回保存路径、调整后的原始文件名以及释放路径锁的 unlock，调用方需要在保存完成后调用 unlock
+func renderFilename(original string, ext string, sum string) (string, string, func(), error) {
+	now := time.Now()
+	values := map[string]string{
+		"year":     strconv.Itoa(now.Year()),
+		"month":    strconv.Itoa(int(now.Month())),
+		"day":      strconv.Itoa(now.Day()),
+		"hour":     strconv.Itoa(now.Hour()),
+		"unix":     strconv.FormatInt(now.Unix(), 10),
+		"uuid":     uuid.NewString(),
+		"original": original,
+		"filename": original,
+		"ext":      ext,
+	}
+	if len(sum) >= 16 {
+		values["hash"] = sum[:16]
+		values["hash8"] = sum[:8]
+	}
+
+	base, err := FilenameTemplate.Render(values)
+	if err != nil {
+		return "", "", nil, err
+	}
+	// 路径包含内容哈希时，同名文件的内容也相同，直接覆盖即可
+	if FilenameTemplate.UsesHash() {
+		return base, original, pathLocks.Lock(base), nil
+	}
+
+	dst := base
+	for range maxRenameAttempts {
+		unlock := pathLocks.Lock(dst)
+		exists, err := Storage.Exists(dst)
+		if err != nil {
+			unlock()
+			return "", "", nil, err
+		}
+		if !exists {
+			return dst, values["original"], unlock, nil
+		}
+		unlock()
+
+		switch {
+		case FilenameTemplate.Uses("uuid"):
+			values["uuid"] = uuid.NewString()
+			dst, err = FilenameTemplate.Render(values)
+		case FilenameTemplate.Uses("original") || FilenameTemplate.Uses("filename"):
+			values["original"] = withSuffix(original)
+			values["filename"] = values["original"]
+			dst, err = FilenameTemplate.Render(values)
+		default:
+			dst = withSuffix(base)
+		}
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	return "", "", nil, errors.New("failed to generate a unique filename")
+}
+
+// reservePath 锁定一个不存在的保存路径：candidate 不存在时直接使用，否则在扩展名之前加上随机后缀重试。
+// 调用方需要在保存完成后调用 unlock
+func reservePath(candidate string) (string, func(), error) {
+	dst := candidate
+	for range maxRenameAttempts {
+		unlock := pathLocks.Lock(dst)
+		exists, err := Storage.Exists(dst)
+		if err != nil {
+			unlock()
+			return "", nil, err
+		}
+		if !exists {
+			return dst, unlock, nil
+		}
+		unlock()
+		dst = withSuffix(candidate)
+	}
+	return "", nil, errors.New("failed to generate a unique filename")
+}
+
+// withSuffix 在文件名的扩展名之前加上 6 位随机十六进制后缀，例如 photo.jpg 变为 photo-3fa2c1.jpg
+func withSuffix(name string) string {
+	dir, file := path.Split(name)
+	ext := path.Ext(file)
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return dir + strings.TrimSuffix(file, ext) + "-" + hex.EncodeToString(b) + ext
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilenameStaysInsideRoot(t *testing.T) {
+	root := t.TempDir()
+	backend := NewLocalBackend(root, "")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"..", ""},
+		{".", ""},
+		{"../../etc/passwd", "passwd"},
+		{"../../../etc/cron.d/x.png", "x.png"},
+		{"/etc/passwd", "passwd"},
+		{"/", ""},
+		{`C:\Windows\System32\evil.png`, "evil.png"},
+		{`..\..\evil.png`, "evil.png"},
+		{`..\\..\\evil.png`, "evil.png"},
+		{`..\`, ""},
+		{"a/../../b.png", "b.png"},
+		{"evil\x00.png", "evil.png"},
+		{"evil.png\x00../../x", "x"},
+		{"\x00", ""},
+		{"..\x00/..", ""},
+		{"photo.png. . ", "photo.png"},
+		{"CON.png", ""},
+		{"cat.png", "cat.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeFilename(tt.name)
+			if got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+			if strings.ContainsAny(got, "/\\\x00") || got == "." || got == ".." {
+				t.Fatalf("sanitizeFilename(%q) = %q contains a path separator or NUL", tt.name, got)
+			}
+			if got == "" {
+				return
+			}
+			dst, err := backend.resolve("2024/5/1/" + got)
+			if err != nil {
+				t.Fatalf("resolve(%q): %v", got, err)
+			}
+			if dir := filepath.Join(root, "2024", "5", "1"); filepath.Dir(dst) != dir {
+				t.Errorf("resolved %q to %q, want a file directly inside %q", got, dst, dir)
+			}
+		})
+	}
+}
+
+func TestLocalBackendResolveRejectsEscapes(t *testing.T) {
+	backend := NewLocalBackend(t.TempDir(), "")
+	for _, name := range []string{"..", "../x.png", "2024/../../x.png", "/../x.png", "", "."} {
+		if dst, err := backend.resolve(name); err == nil {
+			t.Errorf("resolve(%q) = %q, want an error", name, dst)
+		}
+	}
+}
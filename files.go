@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// fileEntry 已上传的文件
+type fileEntry struct {
+	ID               int64     `json:"id"`
+	Name             string    `json:"name"`
+	OriginalFilename string    `json:"original_filename"`
+	URL              string    `json:"url"`
+	Size             int64     `json:"size"`
+	Width            *int      `json:"width"`
+	Height           *int      `json:"height"`
+	SHA256           string    `json:"sha256"`
+	UploadedAt       time.Time `json:"uploaded_at"`
+	MimeType         string    `json:"mime_type"`
+	BlurHash         string    `json:"blurhash,omitempty"`
+	DominantColor    *string   `json:"dominant_color"`
+	UploadedBy       *int64    `json:"uploaded_by_user_id"`
+}
+
+// filesHandler 分页列出已上传的文件，按上传时间倒序，支持 ?page=1&per_page=50&after=<RFC3339>
+func filesHandler(context *gin.Context) {
+	page, perPage, ok := pagination(context)
+	if !ok {
+		return
+	}
+	var after time.Time
+	if v := context.Query("after"); v != "" {
+		var err error
+		after, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "after 必须是 RFC3339 格式的时间！"})
+			return
+		}
+	}
+
+	records, total, err := listImages(page, perPage, after, requestUserID(context))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"data":     fileEntries(records),
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
+
+// pagination 读取 ?page=1&per_page=50 分页参数，参数无效时返回 400 并且 ok 为 false
+func pagination(context *gin.Context) (page int, perPage int, ok bool) {
+	page, err := strconv.Atoi(context.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "page 无效！"})
+		return 0, 0, false
+	}
+	perPage, err = strconv.Atoi(context.DefaultQuery("per_page", "50"))
+	if err != nil || perPage < 1 || perPage > 1000 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "per_page 必须在 1 到 1000 之间！"})
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// fileEntries 把上传记录转换为接口返回的文件信息
+func fileEntries(records []*imageRecord) []fileEntry {
+	files := make([]fileEntry, 0, len(records))
+	for _, record := range records {
+		files = append(files, fileEntry{
+			ID:               record.ID,
+			Name:             path.Base(record.StoredPath),
+			OriginalFilename: record.OriginalFilename,
+			URL:              Storage.URL(record.StoredPath),
+			Size:             record.SizeBytes,
+			Width:            record.Width,
+			Height:           record.Height,
+			SHA256:           record.SHA256,
+			UploadedAt:       record.UploadedAt,
+			MimeType:         record.MimeType,
+			BlurHash:         record.BlurHash,
+			DominantColor:    dominantColorField(record.DominantColor),
+			UploadedBy:       record.UploadedBy,
+		})
+	}
+	return files
+}
+
+// dominantColorField 没有主色调时返回 nil，接口中为 null
+func dominantColorField(color string) *string {
+	if color == "" {
+		return nil
+	}
+	return &color
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"errors"
+	"github.com/gen2brain/webp"
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// GIF 动图转换后的格式
+const (
+	// GIFConvertWebP 转换为动图 WebP
+	GIFConvertWebP = "webp"
+	// GIFConvertMP4 使用 ffmpeg 转换为 MP4 视频
+	GIFConvertMP4 = "mp4"
+)
+
+// convertAnimatedGIF 把动图 GIF 转换为 GIFConvert 格式，保留每一帧的时长，WebP 还会保留循环次数。
+// 超过 GIFConvertTimeout 时返回 errEncodeTimeout，返回转换后的内容和扩展名
+func convertAnimatedGIF(c ctx.Context, data []byte) ([]byte, string, error) {
+	c, cancel := ctx.WithTimeout(c, GIFConvertTimeout)
+	defer cancel()
+
+	var converted []byte
+	var err error
+	if GIFConvert == GIFConvertMP4 {
+		converted, err = gifToMP4(c, data)
+	} else {
+		converted, err = gifToWebP(c, data)
+	}
+	if errors.Is(c.Err(), ctx.DeadlineExceeded) {
+		return nil, "", errEncodeTimeout
+	}
+	return converted, GIFConvert, err
+}
+
+// gifToWebP 把 GIF 的每一帧合成为完整的画面后编码为动图 WebP，c 结束时不再等待编码完成
+func gifToWebP(c ctx.Context, data []byte) ([]byte, error) {
+	if _, err := checkDecodePixels(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	frames, err := gifCanvasFrames(g)
+	if err != nil {
+		return nil, err
+	}
+	anim := &webp.WEBP{Image: frames, LoopCount: webpLoopCount(g.LoopCount)}
+	for _, delay := range g.Delay {
+		// 浏览器把小于 20ms 的帧时长按 100ms 播放，转换后保持相同的速度
+		if delay < 2 {
+			delay = 10
+		}
+		anim.Delay = append(anim.Delay, delay*10)
+	}
+
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- webp.EncodeAll(&buf, anim, webp.Options{Quality: WebPQuality, Method: webp.DefaultMethod})
+	}()
+	select {
+	case err = <-done:
+		return buf.Bytes(), err
+	case <-c.Done():
+		return nil, c.Err()
+	}
+}
+
+// webpLoopCount 把 GIF 的循环次数转换为 WebP 的循环次数。GIF 中 0 表示无限循环，-1 表示只播放一次，
+// n 表示播放后再重复 n 次；WebP 中 0 表示无限循环，n 表示一共播放 n 次
+func webpLoopCount(loopCount int) int {
+	if loopCount < 0 {
+		return 1
+	}
+	if loopCount == 0 {
+		return 0
+	}
+	return loopCount + 1
+}
+
+// gifCanvasFrames 按 GIF 的处置方式把每一帧合成为完整的画面，GIF 中的帧可能只包含变化的区域。
+// 每一帧都会占用整个画面大小的内存，所有帧的像素数之和超过 MaxPixels 时返回 errTooManyPixels
+func gifCanvasFrames(g *gif.GIF) ([]image.Image, error) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+	// 只有几 KB 的 GIF 也可以在很大的画面上放上千个 1x1 的帧
+	if pixels := int64(bounds.Dx()) * int64(bounds.Dy()); MaxPixels > 0 && pixels*int64(len(g.Image)) > MaxPixels {
+		return nil, errTooManyPixels
+	}
+
+	canvas := image.NewNRGBA(bounds)
+	frames := make([]image.Image, 0, len(g.Image))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		snapshot := image.NewNRGBA(bounds)
+		copy(snapshot.Pix, canvas.Pix)
+		frames = append(frames, snapshot)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, nil
+}
+
+// gifToMP4 使用 ffmpeg 把 GIF 转换为 H.264 编码的 MP4，c 结束时终止 ffmpeg。
+// MP4 没有循环次数，需要在页面中使用 <video loop> 播放
+func gifToMP4(c ctx.Context, data []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "gif-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "in.gif"), filepath.Join(dir, "out.mp4")
+	err = os.WriteFile(src, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// H.264 要求宽高为偶数
+	cmd := exec.CommandContext(c, "ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", src,
+		"-movflags", "+faststart", "-pix_fmt", "yuv420p", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-an", dst)
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(output) > 0 {
+			return nil, errors.New(string(bytes.TrimSpace(output)))
+		}
+		return nil, err
+	}
+	return os.ReadFile(dst)
+}
+
+// gifFrames 按块结构统计 GIF 中的帧数，不解码图像数据，最多数到 2
+func gifFrames(data []byte) int {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF")) {
+		return 0
+	}
+	// 跳过文件头、逻辑屏幕描述符和全局颜色表
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+	// skipSubBlocks 跳过以长度为 0 的块结尾的数据子块
+	skipSubBlocks := func() {
+		for pos < len(data) && data[pos] != 0 {
+			pos += int(data[pos]) + 1
+		}
+		pos++
+	}
+
+	frames := 0
+	for pos < len(data) && frames < 2 {
+		switch data[pos] {
+		case 0x21:
+			// 扩展块：标签之后是数据子块
+			pos += 2
+			skipSubBlocks()
+		case 0x2c:
+			// 图像描述符，之后可能有局部颜色表，然后是 LZW 最小码长和图像数据子块
+			if pos+10 > len(data) {
+				return frames
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			skipSubBlocks()
+			frames++
+		default:
+			// 0x3b 文件结束或者格式错误
+			return frames
+		}
+	}
+	return frames
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	ctx "context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthzHandler 存活检查，存储目录可写并且剩余空间不少于 DiskFreeMinBytes 时返回 200
+func healthzHandler(context *gin.Context) {
+	status, reason := checkStorageHealth()
+	if reason != "" {
+		context.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded", "reason": reason})
+		return
+	}
+	context.JSON(http.StatusOK, status)
+}
+
+// readyzHandler 就绪检查，在 healthzHandler 的基础上检查数据库是否可用
+func readyzHandler(context *gin.Context) {
+	status, reason := checkStorageHealth()
+	if reason == "" {
+		c, cancel := ctx.WithTimeout(context.Request.Context(), 2*time.Second)
+		defer cancel()
+		if err := db.PingContext(c); err != nil {
+			reason = "database unavailable: " + err.Error()
+		}
+	}
+	if reason != "" {
+		context.JSON(http.StatusServiceUnavailable, gin.H{"status": "degraded", "reason": reason})
+		return
+	}
+	context.JSON(http.StatusOK, status)
+}
+
+// checkStorageHealth 检查本地存储目录是否可写以及剩余空间，不健康时 reason 不为空
+func checkStorageHealth() (gin.H, string) {
+	local, ok := Storage.(*LocalBackend)
+	if !ok {
+		// 远程存储后端不检查磁盘
+		return gin.H{"status": "ok"}, ""
+	}
+
+	err := os.MkdirAll(local.Root, 0750)
+	if err != nil {
+		return nil, "storage directory is not writable: " + err.Error()
+	}
+	file, err := os.CreateTemp(local.Root, ".healthz-*")
+	if err != nil {
+		return nil, "storage directory is not writable: " + err.Error()
+	}
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+
+	free, err := diskFree(local.Root)
+	if err != nil {
+		return nil, "failed to get free disk space: " + err.Error()
+	}
+	if free < DiskFreeMinBytes {
+		return nil, fmt.Sprintf("free disk space %d bytes is below %d bytes", free, DiskFreeMinBytes)
+	}
+	return gin.H{"status": "ok", "disk_free_bytes": free}, ""
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gen2brain/heic"
+	"image/jpeg"
+)
+
+// heicJPEGQuality HEIC 转换为 JPEG 时使用的质量，没有配置 HEICQuality 时与重新压缩 JPEG 的质量相同
+func heicJPEGQuality() int {
+	if HEICQuality > 0 {
+		return HEICQuality
+	}
+	return jpegQuality()
+}
+
+// convertHEIC 把 HEIC/HEIF 图片转换为 JPEG，图片序列只保留第一帧
+func convertHEIC(data []byte) ([]byte, error) {
+	if _, err := checkDecodePixels(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	img, err := heic.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: heicJPEGQuality()})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/h2non/filetype"
+	"time"
+)
+
+// imageRecord images 表中的一条上传记录
+type imageRecord struct {
+	ID               int64
+	SHA256           string
+	OriginalFilename string
+	StoredPath       string
+	MimeType         string
+	SizeBytes        int64
+	Width            *int
+	Height           *int
+	UploadedAt       time.Time
+	UploaderIP       string
+	// BlurHash 图片的 BlurHash 占位图，无法计算时为空
+	BlurHash string
+	// DominantColor 图片的主色调，格式为 #rrggbb，无法计算时为空
+	DominantColor string
+	// PHash 图片的感知哈希，无法计算时为 nil
+	PHash *uint64
+	// UploadedBy 通过 JWT 登录上传的用户 ID，使用密钥或匿名上传时为 nil
+	UploadedBy *int64
+	// OriginalPath 转换 HEIC 或动图 GIF 时同时保存的原图路径，没有保存原图时为空
+	OriginalPath string
+}
+
+// imageColumns 查询 images 表时的列，顺序与 scanImages 一致
+const imageColumns = `images.id, images.sha256, images.original_filename, images.stored_path, images.mime_type,
+	images.size_bytes, images.width, images.height, images.uploaded_at, images.uploader_ip, images.blurhash,
+	images.dominant_color, images.phash, images.uploaded_by_user_id,
+	images.original_path`
+
+// insertImage 保存上传记录，同一路径的文件被覆盖时更新原来的记录
+func insertImage(record *imageRecord) error {
+	_, err := db.Exec(`INSERT INTO images
+		(sha256, original_filename, stored_path, mime_type, size_bytes, width, height, uploaded_at, uploader_ip, blurhash, dominant_color, phash, uploaded_by_user_id,
+			original_path)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (stored_path) DO UPDATE SET
+			sha256 = excluded.sha256,
+			original_filename = excluded.original_filename,
+			mime_type = excluded.mime_type,
+			size_bytes = excluded.size_bytes,
+			width = excluded.width,
+			height = excluded.height,
+			uploaded_at = excluded.uploaded_at,
+			uploader_ip = excluded.uploader_ip,
+			blurhash = excluded.blurhash,
+			dominant_color = excluded.dominant_color,
+			phash = excluded.phash,
+			uploaded_by_user_id = excluded.uploaded_by_user_id,
+			original_path = excluded.original_path`,
+		record.SHA256, record.OriginalFilename, record.StoredPath, record.MimeType, record.SizeBytes,
+		record.Width, record.Height, record.UploadedAt.Unix(), record.UploaderIP,
+		nullString(record.BlurHash), nullString(record.DominantColor), nullHash(record.PHash), record.UploadedBy,
+		nullString(record.OriginalPath))
+	if err != nil {
+		return err
+	}
+	// 同一路径之前的图片可能已经过期删除，新上传的图片不继承过期时间
+	return clearExpiry(record.StoredPath)
+}
+
+// updateImage 图片处理完成后更新保存路径为 path 的记录的内容信息
+func updateImage(path string, record *imageRecord) error {
+	_, err := db.Exec(`UPDATE images SET sha256 = ?, stored_path = ?, mime_type = ?, size_bytes = ?, width = ?, height = ?
+		WHERE stored_path = ?`,
+		record.SHA256, record.StoredPath, record.MimeType, record.SizeBytes, record.Width, record.Height, path)
+	return err
+}
+
+// findBlurHash 返回保存路径为 path 的记录中的 BlurHash，没有记录时 found 为 false
+func findBlurHash(path string) (hash string, found bool, err error) {
+	var value sql.NullString
+	err = db.QueryRow(`SELECT blurhash FROM images WHERE stored_path = ?`, path).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return value.String, err == nil, err
+}
+
+// setBlurHash 保存路径为 path 的记录的 BlurHash
+func setBlurHash(path string, hash string) error {
+	_, err := db.Exec(`UPDATE images SET blurhash = ? WHERE stored_path = ?`, hash, path)
+	return err
+}
+
+// nullString 空字符串保存为 NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullHash 把 64 位哈希按 SQLite 的有符号整数保存，nil 保存为 NULL
+func nullHash(hash *uint64) sql.NullInt64 {
+	if hash == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*hash), Valid: true}
+}
+
+// mimeType 返回扩展名 ext 对应的 MIME 类型
+func mimeType(ext string) string {
+	return filetype.GetType(ext).MIME.Value
+}
+
+// deleteImage 删除保存路径为 path 的上传记录
+func deleteImage(path string) error {
+	_, err := db.Exec(`DELETE FROM images WHERE stored_path = ?`, path)
+	return err
+}
+
+// findImageBySHA256 查找内容为 sum 的最早保存的文件路径，没有时返回空字符串
+func findImageBySHA256(sum string) (string, error) {
+	var path string
+	err := db.QueryRow(`SELECT stored_path FROM images WHERE sha256 = ? ORDER BY id LIMIT 1`, sum).Scan(&path)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return path, err
+}
+
+// findImage 查找保存路径为 path 的上传记录，没有时返回 nil
+func findImage(path string) (*imageRecord, error) {
+	rows, err := db.Query(`SELECT `+imageColumns+` FROM images WHERE stored_path = ?`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records, err := scanImages(rows)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// randomImage 随机返回一张 MIME 类型为 mime（为空时不限）的图片的保存路径，没有时返回空字符串
+func randomImage(mime string) (string, error) {
+	var path string
+	var err error
+	if mime == "" {
+		err = db.QueryRow(`SELECT stored_path FROM images ORDER BY RANDOM() LIMIT 1`).Scan(&path)
+	} else {
+		err = db.QueryRow(`SELECT stored_path FROM images WHERE mime_type = ? ORDER BY RANDOM() LIMIT 1`, mime).Scan(&path)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return path, err
+}
+
+// listImages 按上传时间倒序分页列出在 after 之后上传的记录，同时返回记录总数，userID 不为 nil 时只列出该用户上传的记录
+func listImages(page int, perPage int, after time.Time, userID *int64) ([]*imageRecord, int, error) {
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM images WHERE uploaded_at > ?1 AND (?2 IS NULL OR uploaded_by_user_id = ?2)`,
+		after.Unix(), userID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT `+imageColumns+`
+		FROM images WHERE uploaded_at > ?1 AND (?2 IS NULL OR uploaded_by_user_id = ?2)
+		ORDER BY uploaded_at DESC, id DESC LIMIT ?3 OFFSET ?4`,
+		after.Unix(), userID, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records, err := scanImages(rows)
+	return records, total, err
+}
+
+// scanImages 读取 images 表中按 imageColumns 顺序查询的所有行
+func scanImages(rows *sql.Rows) ([]*imageRecord, error) {
+	records := []*imageRecord{}
+	for rows.Next() {
+		var record imageRecord
+		var width, height sql.NullInt64
+		var uploadedAt int64
+		var blurHash, color, originalPath sql.NullString
+		var pHash, uploadedBy sql.NullInt64
+		err := rows.Scan(&record.ID, &record.SHA256, &record.OriginalFilename, &record.StoredPath, &record.MimeType,
+			&record.SizeBytes, &width, &height, &uploadedAt, &record.UploaderIP, &blurHash, &color, &pHash, &uploadedBy,
+			&originalPath)
+		if err != nil {
+			return nil, err
+		}
+		if width.Valid && height.Valid {
+			w, h := int(width.Int64), int(height.Int64)
+			record.Width, record.Height = &w, &h
+		}
+		record.UploadedAt = time.Unix(uploadedAt, 0)
+		record.BlurHash = blurHash.String
+		record.DominantColor = color.String
+		if pHash.Valid {
+			hash := uint64(pHash.Int64)
+			record.PHash = &hash
+		}
+		record.OriginalPath = originalPath.String
+		if uploadedBy.Valid {
+			record.UploadedBy = &uploadedBy.Int64
+		}
+		records = append(records, &record)
+	}
+	return records, rows.Err()
+}
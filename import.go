@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress 目标地址属于内网
+var errPrivateAddress = errors.New("不允许访问内网地址")
+
+// importRequest 通过链接上传图片的请求体
+type importRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// importHandler 下载远程图片并保存
+func importHandler(context *gin.Context) {
+	var req importRequest
+	if err := context.ShouldBindJSON(&req); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请提供图片链接！"})
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "图片链接无效！"})
+		return
+	}
+
+	data, uploadErr := fetchImage(u.String())
+	if uploadErr != nil {
+		respondUploadError(context, uploadErr)
+		return
+	}
+
+	fileName := path.Base(u.Path)
+	if fileName == "." || fileName == "/" {
+		fileName = "image"
+	}
+
+	result, uploadErr := saveImage(context, fileName, bytes.NewReader(data), int64(len(data)), requestUploadOptions(context))
+	if uploadErr != nil {
+		respondUploadError(context, uploadErr)
+		return
+	}
+	context.JSON(http.StatusOK,
+		gin.H{
+			"message": "图片上传成功！",
+			"data":    result,
+		},
+	)
+}
+
+// fetchImage 下载远程图片，超过 ImportMaxSize 或者不是图片类型时返回错误
+func fetchImage(rawURL string) ([]byte, *uploadError) {
+	resp, err := importClient.Get(rawURL)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return nil, &uploadError{http.StatusBadRequest, errPrivateAddress.Error()}
+		}
+		return nil, &uploadError{http.StatusBadGateway, "下载图片失败：" + err.Error()}
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &uploadError{http.StatusBadGateway, fmt.Sprintf("下载图片失败：%s", resp.Status)}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return nil, &uploadError{http.StatusBadRequest, "仅允许上传图片类型！"}
+	}
+
+	if resp.ContentLength > ImportMaxSize {
+		return nil, &uploadError{http.StatusBadRequest, "远程图片过大！"}
+	}
+
+	// 多读一个字节用来判断是否超过大小限制
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ImportMaxSize+1))
+	if err != nil {
+		return nil, &uploadError{http.StatusBadGateway, "下载图片失败：" + err.Error()}
+	}
+	if int64(len(data)) > ImportMaxSize {
+		return nil, &uploadError{http.StatusBadRequest, "远程图片过大！"}
+	}
+	return data, nil
+}
+
+// importClient 下载远程图片使用的客户端，每次建立连接时都会检查目标地址，重定向到内网也会被拒绝
+var importClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: denyPrivateAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("重定向次数过多")
+		}
+		return nil
+	},
+}
+
+// denyPrivateAddress 拒绝连接内网、回环等地址，BlockPrivateIPs 为 false 时不检查
+func denyPrivateAddress(_ string, address string, _ syscall.RawConn) error {
+	if !BlockPrivateIPs {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// sharedAddressSpace 运营商级 NAT 地址段 100.64.0.0/10
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPrivateIP 判断是否为内网、回环、链路本地等不应从外部访问的地址
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	ctx "context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/h2non/filetype"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// exifField 在 /info 中返回的 EXIF 标签，GPS 等隐私信息不会返回
+type exifField struct {
+	name string
+	// exif 为 true 时标签在 EXIF 子 IFD 中，否则在 IFD0 中
+	exif bool
+}
+
+// exifFields /info 返回的 EXIF 标签白名单
+var exifFields = map[uint16]exifField{
+	0x010F:         {"make", false},
+	0x0110:         {"model", false},
+	orientationTag: {"orientation", false},
+	0x0131:         {"software", false},
+	0x829A:         {"exposure_time", true},
+	0x829D:         {"f_number", true},
+	0x8827:         {"iso", true},
+	0x9003:         {"date_time_original", true},
+	0x920A:         {"focal_length", true},
+	0xA434:         {"lens_model", true},
+}
+
+// exifIFDTag IFD0 中指向 EXIF 子 IFD 的标签
+const exifIFDTag = 0x8769
+
+// infoHandler 返回 ./static 下图片的格式、宽高、大小、修改时间、BlurHash 以及白名单内的 EXIF 信息。
+// 只读取文件头，不解码图像数据，只有上传记录中还没有 BlurHash 时才会解码一次并保存。
+// 与 /static 一样开启 REQUIRE_SIGNED_URLS 时需要签名
+func infoHandler(context *gin.Context) {
+	name := strings.TrimPrefix(context.Param("path"), "/")
+	src, err := (&LocalBackend{Root: staticDir}).resolve(name)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效！"})
+		return
+	}
+	if !checkImageAccess(context, name) {
+		return
+	}
+	stat, err := os.Stat(src)
+	if err != nil || stat.IsDir() {
+		context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// 无法识别的文件按扩展名返回格式
+	format := normalizeType(strings.TrimPrefix(path.Ext(name), "."))
+	r := bufio.NewReader(file)
+	head, _ := r.Peek(261)
+	if kind, _ := filetype.Match(head); kind != filetype.Unknown {
+		format = normalizeType(kind.Extension)
+	}
+	info := gin.H{
+		"path":        name,
+		"format":      format,
+		"width":       nil,
+		"height":      nil,
+		"size":        stat.Size(),
+		"modified_at": stat.ModTime(),
+	}
+	if config, _, err := image.DecodeConfig(r); err == nil {
+		info["width"], info["height"] = config.Width, config.Height
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err == nil {
+		if fields := exifInfo(readEXIF(file, format)); len(fields) > 0 {
+			info["exif"] = fields
+		}
+	}
+	if hash := storedBlurHash(context, name, stat, file); hash != "" {
+		info["blurhash"] = hash
+	}
+	context.JSON(http.StatusOK, info)
+}
+
+// blurHashLocks 同一张图片同时请求 /info 时只解码一次
+var blurHashLocks = newKeyedMutex()
+
+// storedBlurHash 返回上传记录中图片 name 的 BlurHash，之前没有计算过时读取 file 计算，有上传记录时保存到记录中，
+// 否则保存在 blurHashCache 中，每张图片最多解码一次。失败时返回空字符串
+func storedBlurHash(c ctx.Context, name string, stat os.FileInfo, file io.ReadSeeker) string {
+	hash, found, err := findBlurHash(name)
+	if err != nil {
+		slog.ErrorContext(c, "failed to query blurhash", "path", name, "error", err)
+		return ""
+	}
+	if hash != "" {
+		return hash
+	}
+
+	// 文件被替换后修改时间或大小会改变，之前缓存的结果不再使用
+	key := fmt.Sprintf("%s\x00%d\x00%d", name, stat.ModTime().UnixNano(), stat.Size())
+	unlock := blurHashLocks.Lock(key)
+	defer unlock()
+	if hash, ok := blurHashCache.get(key); ok {
+		return hash
+	}
+
+	preview := decodePreview(func() (io.Reader, error) {
+		_, err := file.Seek(0, io.SeekStart)
+		return file, err
+	})
+	if preview != nil {
+		hash = imageBlurHash(preview)
+	}
+	if found && hash != "" {
+		if err := setBlurHash(name, hash); err != nil {
+			slog.ErrorContext(c, "failed to save blurhash", "path", name, "error", err)
+		}
+	}
+	// 无法解码的图片也缓存空结果，避免每次请求都重新解码
+	blurHashCache.add(key, hash)
+	return hash
+}
+
+// readEXIF 读取 JPEG、PNG 或 WebP 中 TIFF 格式的 EXIF 数据，只读取段或块的头部并跳过其它数据，没有时返回 nil
+func readEXIF(r io.ReadSeeker, format string) []byte {
+	switch format {
+	case "jpg":
+		return readJPEGEXIF(bufio.NewReader(r))
+	case "png":
+		return readChunk(r, len(pngSignature), binary.BigEndian, "eXIf", 4)
+	case "webp":
+		return readChunk(r, 12, binary.LittleEndian, "EXIF", 0)
+	}
+	return nil
+}
+
+// readJPEGEXIF 在 JPEG 开头的段中查找 EXIF 所在的 APP1 段，遇到 SOS 时停止
+func readJPEGEXIF(r *bufio.Reader) []byte {
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:2]); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return nil
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return nil
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil
+		}
+		if marker[1] != 0xE1 {
+			if _, err := r.Discard(size); err != nil {
+				return nil
+			}
+			continue
+		}
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil
+		}
+		if tiff, found := bytes.CutPrefix(segment, exifHeader); found {
+			return tiff
+		}
+	}
+}
+
+// readChunk 从 offset 开始按 PNG 或 WebP 的块结构查找类型为 typ 的块并返回其数据。
+// PNG 的块是长度、类型、数据和 4 字节 CRC，WebP 的块是类型、长度和数据，数据长度为奇数时有填充字节
+func readChunk(r io.ReadSeeker, offset int, order binary.ByteOrder, typ string, crc int) []byte {
+	if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil
+	}
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil
+		}
+		name, length := string(header[4:]), header[:4]
+		if crc == 0 {
+			name, length = string(header[:4]), header[4:]
+		}
+		size := int64(order.Uint32(length))
+		if name == typ {
+			if size > int64(MaxFileSize) {
+				return nil
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil
+			}
+			// 部分软件写入的 WebP EXIF 块仍然带有 Exif\0\0 前缀
+			return bytes.TrimPrefix(data, exifHeader)
+		}
+		skip := size + int64(crc)
+		if crc == 0 {
+			skip += size % 2
+		}
+		if _, err := r.Seek(skip, io.SeekCurrent); err != nil {
+			return nil
+		}
+	}
+}
+
+// exifInfo 读取 TIFF 格式的 EXIF 数据 tiff 中 exifFields 白名单内的标签
+func exifInfo(tiff []byte) gin.H {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	fields := gin.H{}
+	ifd0 := int(order.Uint32(tiff[4:8]))
+	exifIFD := readIFD(tiff, order, ifd0, false, fields)
+	if exifIFD > 0 {
+		readIFD(tiff, order, exifIFD, true, fields)
+	}
+	return fields
+}
+
+// readIFD 把 offset 处的 IFD 中属于 exifFields 的标签写入 fields，返回其中 EXIF 子 IFD 的位置，没有时返回 0
+func readIFD(tiff []byte, order binary.ByteOrder, offset int, exif bool, fields gin.H) int {
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	exifIFD := 0
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for k := 0; k < count; k++ {
+		entry := offset + 2 + 12*k
+		if entry+12 > len(tiff) {
+			break
+		}
+		tag := order.Uint16(tiff[entry : entry+2])
+		if tag == exifIFDTag && !exif {
+			exifIFD = int(order.Uint32(tiff[entry+8 : entry+12]))
+			continue
+		}
+		field, ok := exifFields[tag]
+		if !ok || field.exif != exif {
+			continue
+		}
+		if value, err := exifValue(tiff, order, tiff[entry:entry+12]); err == nil {
+			fields[field.name] = value
+		}
+	}
+	return exifIFD
+}
+
+// exifValue 读取 IFD 条目 entry 的值，支持 ASCII、SHORT、LONG 和 RATIONAL 类型，多个值时只取第一个
+func exifValue(tiff []byte, order binary.ByteOrder, entry []byte) (any, error) {
+	typ := order.Uint16(entry[2:4])
+	count := int(order.Uint32(entry[4:8]))
+	sizes := map[uint16]int{2: 1, 3: 2, 4: 4, 5: 8}
+	size, ok := sizes[typ]
+	if !ok || count < 1 || count > len(tiff) {
+		return nil, errors.New("unsupported exif value")
+	}
+	// 不超过 4 字节的值直接保存在条目中，否则条目中是值的位置
+	value := entry[8:12]
+	if size*count > 4 {
+		start := int(order.Uint32(entry[8:12]))
+		if start < 0 || start+size*count > len(tiff) {
+			return nil, errors.New("exif value out of range")
+		}
+		value = tiff[start : start+size*count]
+	}
+
+	switch typ {
+	case 2:
+		return strings.TrimSpace(strings.TrimRight(string(value[:count]), "\x00")), nil
+	case 3:
+		return order.Uint16(value), nil
+	case 4:
+		return order.Uint32(value), nil
+	}
+	numerator, denominator := order.Uint32(value[:4]), order.Uint32(value[4:8])
+	if denominator == 0 {
+		return nil, errors.New("invalid rational")
+	}
+	return float64(numerator) / float64(denominator), nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobTTL 处理完成的任务保留的时间
+const jobTTL = time.Hour
+
+// 任务状态
+const (
+	JobPending    = "pending"
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobFailed     = "failed"
+)
+
+// processingJob 异步处理一张已经保存的原图：缩放、水印、格式转换和生成缩略图
+type processingJob struct {
+	ID string
+
+	mu       sync.Mutex
+	status   string
+	result   gin.H
+	err      string
+	finished time.Time
+
+	// dst 原图的保存路径，ext 为原图的扩展名，data 为原图内容，watermark 为是否添加水印
+	dst       string
+	ext       string
+	data      []byte
+	watermark bool
+}
+
+// processingQueue 有界的处理队列，slots 满时拒绝新的任务
+type processingQueue struct {
+	slots chan struct{}
+	queue chan *processingJob
+
+	mu   sync.Mutex
+	jobs map[string]*processingJob
+	// closed 关闭服务时为 true，不再接受新的任务
+	closed bool
+	// pending 已经占用位置但还没有处理完成的任务
+	pending sync.WaitGroup
+}
+
+// jobQueue 异步处理队列，开启 AsyncProcessing 时在 main 中创建
+var jobQueue *processingQueue
+
+// startProcessingQueue 创建容量为 size 的处理队列并启动 workers 个 worker
+func startProcessingQueue(size int, workers int) *processingQueue {
+	q := &processingQueue{
+		slots: make(chan struct{}, size),
+		queue: make(chan *processingJob, size),
+		jobs:  map[string]*processingJob{},
+	}
+	for range workers {
+		go q.work()
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			q.cleanup(jobTTL)
+		}
+	}()
+	return q
+}
+
+// reserve 占用队列中的一个位置，队列已满或者正在关闭时返回 false
+func (q *processingQueue) reserve() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.slots <- struct{}{}:
+		q.pending.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// release 释放 reserve 占用的位置
+func (q *processingQueue) release() {
+	<-q.slots
+	q.pending.Done()
+}
+
+// drain 停止接受新的任务，等待已经接受的任务全部处理完成，c 结束时不再等待并返回 c.Err()
+func (q *processingQueue) drain(c ctx.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-c.Done():
+		return c.Err()
+	}
+}
+
+// enqueue 把任务加入队列，调用前需要先 reserve
+func (q *processingQueue) enqueue(dst string, ext string, data []byte, watermark bool) (*processingJob, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	job := &processingJob{ID: hex.EncodeToString(b), status: JobPending, dst: dst, ext: ext, data: data, watermark: watermark}
+
+	q.mu.Lock()
+	q.jobs[job.ID] = job
+	q.mu.Unlock()
+
+	q.queue <- job
+	return job, nil
+}
+
+// get 返回 id 对应的任务
+func (q *processingQueue) get(id string) (*processingJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	return job, ok
+}
+
+// cleanup 删除完成超过 ttl 的任务
+func (q *processingQueue) cleanup(ttl time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for id, job := range q.jobs {
+		job.mu.Lock()
+		expired := !job.finished.IsZero() && time.Since(job.finished) > ttl
+		job.mu.Unlock()
+		if expired {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *processingQueue) work() {
+	for job := range q.queue {
+		job.setStatus(JobProcessing)
+		result, err := processImage(job.dst, job.ext, job.data, job.watermark)
+
+		job.mu.Lock()
+		if err != nil {
+			slog.Error("failed to process image", "job_id", job.ID, "path", job.dst, "error", err)
+			job.status = JobFailed
+			job.err = err.Error()
+		} else {
+			job.status = JobDone
+			job.result = result
+		}
+		job.finished = time.Now()
+		// 处理完成后不再需要原图内容
+		job.data = nil
+		job.mu.Unlock()
+
+		q.release()
+	}
+}
+
+func (j *processingJob) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+// processImage 对已经保存在 dst 的原图执行 transformImage 并生成缩略图，返回最终的地址。
+// 扩展名改变时（例如转换为 WebP）保存到新的路径并删除原图，新路径已经被其它图片使用时加上随机后缀
+func processImage(dst string, ext string, data []byte, watermark bool) (gin.H, error) {
+	final := dst
+	if needsTransform(ext, watermark) {
+		processed, newExt, err := transformImage(data, ext, watermark)
+		if err != nil {
+			return nil, err
+		}
+		if newExt != ext {
+			var unlock func()
+			final, unlock, err = reservePath(strings.TrimSuffix(dst, path.Ext(dst)) + "." + newExt)
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+		}
+		if final != dst || !bytes.Equal(processed, data) {
+			err = Storage.Save(final, bytes.NewReader(processed), int64(len(processed)))
+			if err != nil {
+				return nil, err
+			}
+			if final != dst {
+				_ = Storage.Delete(dst)
+			}
+			purgeVariants(dst)
+			data = processed
+		}
+		ext = newExt
+	}
+
+	source := func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	}
+	width, height, err := imageDimensions(source)
+	if err != nil {
+		slog.Debug("failed to read image dimensions", "path", final, "error", err)
+	}
+	sha256Sum := sha256Hex(data)
+	err = updateImage(dst, &imageRecord{
+		SHA256:     sha256Sum,
+		StoredPath: final,
+		MimeType:   mimeType(ext),
+		SizeBytes:  int64(len(data)),
+		Width:      width,
+		Height:     height,
+	})
+	if err != nil {
+		slog.Error("failed to update image record", "path", dst, "error", err)
+	}
+
+	return gin.H{
+		"url":           Storage.URL(final),
+		"thumbnail_url": thumbnailURL(final, false, source),
+		"size":          len(data),
+		"sha256":        sha256Sum,
+		"width":         pixels(width),
+		"height":        pixels(height),
+	}, nil
+}
+
+// jobHandler 查询异步处理任务 /jobs/:id 的状态，完成后返回最终的图片地址
+func jobHandler(context *gin.Context) {
+	if jobQueue == nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "未开启异步处理！"})
+		return
+	}
+	job, ok := jobQueue.get(context.Param("id"))
+	if !ok {
+		context.JSON(http.StatusNotFound, gin.H{"error": "任务不存在！"})
+		return
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	response := gin.H{"id": job.ID, "status": job.status}
+	switch job.status {
+	case JobDone:
+		response["data"] = job.result
+	case JobFailed:
+		response["error"] = job.err
+	}
+	context.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessingQueueDrainWaitsForJobs(t *testing.T) {
+	useTestStorage(t)
+	q := startProcessingQueue(4, 1)
+
+	var jobs []*processingJob
+	for range 3 {
+		if !q.reserve() {
+			t.Fatal("reserve failed on an empty queue")
+		}
+		job, err := q.enqueue("2024/5/1/tiny.gif", "gif", tinyGIF, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	c, cancel := ctx.WithTimeout(ctx.Background(), 10*time.Second)
+	defer cancel()
+	if err := q.drain(c); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	for _, job := range jobs {
+		job.mu.Lock()
+		status := job.status
+		job.mu.Unlock()
+		if status != JobDone && status != JobFailed {
+			t.Errorf("job %s is %s after drain, want finished", job.ID, status)
+		}
+	}
+	if q.reserve() {
+		t.Error("reserve succeeded after drain")
+	}
+}
+
+func TestProcessingQueueDrainTimeout(t *testing.T) {
+	q := startProcessingQueue(1, 1)
+	// 占用位置但一直不加入队列，drain 只能等到超时
+	if !q.reserve() {
+		t.Fatal("reserve failed on an empty queue")
+	}
+	c, cancel := ctx.WithTimeout(ctx.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.drain(c); !errors.Is(err, ctx.DeadlineExceeded) {
+		t.Errorf("drain error = %v, want %v", err, ctx.DeadlineExceeded)
+	}
+	q.release()
+}
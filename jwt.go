@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// errInvalidToken JWT 格式、算法或签名不正确
+var errInvalidToken = errors.New("登录凭证无效！")
+
+// errTokenExpired JWT 已经超过 exp 指定的时间
+var errTokenExpired = errors.New("登录已过期，请重新登录！")
+
+// jwtHeader 固定使用 HS256 签名的 JWT 头
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims 登录后签发的 JWT 中的内容
+type tokenClaims struct {
+	UserID    int64 `json:"user_id"`
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// signToken 使用 JWT_SECRET 为用户 userID 签发有效期为 JWTTTL 的 JWT
+func signToken(userID int64) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(JWTTTL)
+	payload, err := json.Marshal(tokenClaims{UserID: userID, IssuedAt: now.Unix(), ExpiresAt: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + tokenSignature(unsigned), expires, nil
+}
+
+// parseToken 校验 JWT 的签名和有效期，返回其中的用户 ID。只接受 HS256，拒绝 alg 为 none 等其它算法的令牌
+func parseToken(token string) (int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errInvalidToken
+	}
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(tokenSignature(unsigned))) {
+		return 0, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil || header.Alg != "HS256" {
+		return 0, errInvalidToken
+	}
+	var claims tokenClaims
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(data, &claims) != nil || claims.UserID <= 0 || claims.ExpiresAt == 0 {
+		return 0, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return 0, errTokenExpired
+	}
+	return claims.UserID, nil
+}
+
+// tokenSignature 计算 JWT 前两段的 HMAC-SHA256 签名
+func tokenSignature(unsigned string) string {
+	mac := hmac.New(sha256.New, []byte(JWTSecret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"html"
+	"strings"
+)
+
+// markdownAltEscaper 转义 Markdown 图片描述中的特殊字符
+var markdownAltEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// markdownURLEscaper 转义 Markdown 链接中会提前结束链接的字符
+var markdownURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
+
+// bbcodeURLEscaper 转义 BBCode 链接中的方括号
+var bbcodeURLEscaper = strings.NewReplacer("[", "%5B", "]", "%5D")
+
+// imageLinks 生成可以直接粘贴使用的 Markdown、BBCode 和 HTML 格式链接
+func imageLinks(name string, url string) gin.H {
+	return gin.H{
+		"url":      url,
+		"markdown": "![" + markdownAltEscaper.Replace(name) + "](" + markdownURLEscaper.Replace(url) + ")",
+		"bbcode":   "[img]" + bbcodeURLEscaper.Replace(url) + "[/img]",
+		"html":     `<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(name) + `">`,
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	ctx "context"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// 日志格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// newLogger 创建输出到标准输出的日志，format 为 json 时每行输出一个 JSON 对象
+func newLogger(format string) *slog.Logger {
+	if format == LogFormatJSON {
+		return slog.New(requestIDHandler{slog.NewJSONHandler(os.Stdout, nil)})
+	}
+	return slog.New(requestIDHandler{slog.NewTextHandler(os.Stdout, nil)})
+}
+
+// requestIDHandler 处理请求时通过 slog.ErrorContext 等函数传入 gin.Context，日志中会带上请求 ID
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(c ctx.Context, r slog.Record) error {
+	if requestID, ok := c.Value(requestIDKey).(string); ok {
+		r.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(c, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// fatal 记录错误日志后退出程序
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestIDKey 请求 ID 在 gin.Context 中的键
+const requestIDKey = "request_id"
+
+// requestLogger 替代 gin 默认的日志中间件，每个请求结束后记录一条日志。
+// 请求头带有 X-Request-Id 时沿用该 ID，否则生成新的 ID，保存在 gin.Context 中并通过响应头 X-Request-Id 返回
+func requestLogger(context *gin.Context) {
+	start := time.Now()
+	requestID := context.GetHeader("X-Request-Id")
+	if requestID == "" || len(requestID) > 128 {
+		requestID = uuid.NewString()
+	}
+	context.Set(requestIDKey, requestID)
+	context.Header("X-Request-Id", requestID)
+
+	context.Next()
+
+	status := context.Writer.Status()
+	attrs := []any{
+		slog.String("request_id", requestID),
+		slog.String("method", context.Request.Method),
+		slog.String("path", context.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("client_ip", context.ClientIP()),
+	}
+	if context.Request.ContentLength > 0 {
+		attrs = append(attrs, slog.Int64("file_size", context.Request.ContentLength))
+	}
+	if len(context.Errors) > 0 {
+		attrs = append(attrs, slog.String("error", strings.Join(context.Errors.Errors(), "; ")))
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Request.Context(), level, "request", attrs...)
+}
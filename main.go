@@ -2,69 +2,65 @@ package main
 
 import (
 	"embed"
-	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/h2non/filetype"
-	"github.com/joho/godotenv"
 	"io"
 	"io/fs"
-	"log"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 )
 
-// MaxFileSize 允许上传的最大文件大小
-const MaxFileSize = 10 << 20 // 10 MB
-
-// AllowOrigins 允许域
-var AllowOrigins []string
-
-// Port 端口
-var Port string
-
-// Url 返回的图片Url前缀
-var Url string
-
 //go:embed html/*
 var htmlFS embed.FS
 
-func init() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	Port = os.Getenv("PORT")
-	if Port == "" {
-		Port = "8080"
-	}
-	AllowOrigins = strings.Split(os.Getenv("AllowOrigins"), ",")
-	Url = os.Getenv("URL")
-	if Url == "" {
-		Url = "http://127.0.0.1:" + Port
+func main() {
+	router := gin.New()
+	// 处理函数可以把 gin.Context 作为 context.Context 传递，其中包含请求 ID 和追踪的 span
+	router.ContextWithFallback = true
+	router.Use(requestLogger, gin.Recovery(), rejectDuringShutdown)
+
+	// 配置了 OTEL_EXPORTER_OTLP_ENDPOINT 时记录追踪数据，否则不创建任何 span
+	if TracingEnabled {
+		if err := setupTracing(); err != nil {
+			fatal("error setting up tracing", "error", err)
+		}
+		router.Use(tracingMiddleware)
 	}
-}
 
-func main() {
-	router := gin.Default()
+	// 安全相关的响应头
+	router.Use(securityHeadersMiddleware())
 
 	// CORS
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     AllowOrigins,
-		AllowMethods:     []string{"GET", "POST"},
-		AllowHeaders:     []string{"Origin"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "HEAD", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "X-Upload-Id", "X-Generate-Filename", "X-Content-SHA256", "X-Content-MD5"},
+		ExposeHeaders:    []string{"Content-Length", "Location", "Tus-Resumable", "Upload-Length", "Upload-Offset"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	router.Static("/static", "./static")
+	// 统计正在处理的请求数
+	router.Use(activeRequestsMetrics)
+
+	// 只有配置了信任的代理时才使用 X-Forwarded-For 中的客户端 IP
+	err := router.SetTrustedProxies(TrustedProxies)
+	if err != nil {
+		fatal("invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	// 已上传的图片，开启 REQUIRE_SIGNED_URLS 时需要签名
+	router.GET("/static/*filepath", staticHandler)
+	router.HEAD("/static/*filepath", staticHandler)
 
 	// 为 multipart forms 设置较低的内存限制 (默认是 32 MiB)
-	router.MaxMultipartMemory = MaxFileSize // 10 MiB
+	router.MaxMultipartMemory = MaxFileSize
+
+	// 存活检查和就绪检查
+	router.GET("/healthz", healthzHandler)
+	router.GET("/readyz", readyzHandler)
 
 	// 首页
 	router.GET("/", indexHandler)
@@ -72,74 +68,112 @@ func main() {
 	// 前端页面处理
 	router.GET("/html/*filepath", htmlHandler)
 
-	// 上传接口，仅允许上传图片
-	router.POST("/upload", uploadHandler)
+	// 按 IP 限制上传频率
+	uploadLimit := rateLimit(UploadsPerMinute)
 
-	err := router.Run(":" + Port)
-	if err != nil {
-		panic(err)
+	// 用户注册和登录，需要配置 JWT_SECRET，登录后返回的 JWT 可以代替 API_KEY 上传、删除和列出图片
+	if JWTSecret != "" {
+		router.POST("/auth/register", uploadLimit, registerHandler)
+		router.POST("/auth/login", uploadLimit, loginHandler)
 	}
-}
 
-func uploadHandler(context *gin.Context) {
+	// 上传相关接口，配置了 API_KEY 时需要携带密钥，配置了 JWT_SECRET 时需要登录或者携带 API_KEY
+	upload := router.Group("/upload", uploadMetrics, uploadLimit, userAuth(APIKey, "API_KEY", false), rejectWhenDiskFull, trackProgress)
 
-	upload, err := context.FormFile("file")
+	// 上传接口，仅允许上传图片，支持一次上传多张
+	upload.POST("", uploadHandler)
 
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
+	// 删除图片，必须配置 API_KEY 或者登录，登录的用户只能删除自己上传的图片
+	upload.DELETE("/:year/:month/:day/:filename", userAuth(APIKey, "API_KEY", true), deleteHandler)
 
-	if upload.Size > MaxFileSize {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "请将图片大小压缩至不超过10MB！"})
-		return
-	}
+	// 直接使用请求体上传图片
+	upload.PUT("/raw", rawHandler)
+	upload.PUT("/raw/:filename", rawHandler)
 
-	file, err := upload.Open()
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
+	// 通过 Server-Sent Events 获取请求头带有 X-Upload-Id 的上传进度
+	router.GET("/upload/progress/:id", userAuth(APIKey, "API_KEY", false), progressHandler)
 
-	defer func(file multipart.File) {
-		err := file.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(file)
+	// 上传 ZIP 压缩包，批量保存其中的图片
+	upload.POST("/zip", zipHandler)
 
-	head := make([]byte, 261)
-	_, err = file.Read(head)
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
+	// 通过链接上传图片，/import 与 /upload/url 相同
+	upload.POST("/url", importHandler)
+	router.POST("/import", uploadMetrics, uploadLimit, userAuth(APIKey, "API_KEY", false), rejectWhenDiskFull, importHandler)
 
-	if !filetype.IsImage(head) {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "仅允许上传图片类型！"})
-		return
+	// 上传 base64 编码的图片
+	upload.POST("/base64", base64Handler)
+
+	// 生成带有效期的图片地址，必须配置 API_KEY
+	router.GET("/sign/*path", apiKeyAuth(true), signHandler)
+
+	// 把已上传的图片转换为其他格式，与 /static 一样开启 REQUIRE_SIGNED_URLS 时需要签名
+	router.GET("/convert/*path", convertHandler)
+
+	// 在服务端裁剪图片，与缩放一样缓存结果
+	router.GET("/crop/*path", cropHandler)
+
+	// 图片的预览页面，带有 Open Graph 标签，分享到聊天软件和社交网站时显示图片预览
+	router.GET("/view/:year/:month/:day/:filename", viewHandler)
+
+	// 最近上传的图片的 RSS 订阅
+	router.GET("/feed.rss", feedHandler)
+
+	// 随机返回一张已上传的图片，可以通过 ?mime=image/jpeg 限制类型
+	router.GET("/random", randomHandler)
+
+	// 查看图片的格式、宽高、大小和部分 EXIF 信息，只读取文件头
+	router.GET("/info/*path", infoHandler)
+
+	// 图片下载次数统计，GET /stats/top 返回下载次数最多的图片
+	router.GET("/stats/*path", statsHandler)
+
+	// Prometheus 指标，需要 METRICS_TOKEN
+	router.GET("/metrics", keyAuth(MetricsToken, "METRICS_TOKEN", true), metricsHandler)
+
+	// 异步处理任务的状态
+	if AsyncProcessing {
+		jobQueue = startProcessingQueue(ProcessingQueueSize, ProcessingWorkers)
 	}
+	router.GET("/jobs/:id", jobHandler)
 
-	fileName := upload.Filename
+	// 相册，创建、删除相册以及加入图片时与上传接口一样需要 API_KEY
+	router.GET("/albums", listAlbumsHandler)
+	router.GET("/albums/:id", albumHandler)
+	router.POST("/albums", apiKeyAuth(false), createAlbumHandler)
+	router.DELETE("/albums/:id", apiKeyAuth(false), deleteAlbumHandler)
+	router.POST("/albums/:id/images", apiKeyAuth(false), addAlbumImageHandler)
 
-	now := time.Now()
+	// 列出已上传的文件，需要 ADMIN_KEY，登录的用户只能看到自己上传的文件
+	router.GET("/files", userAuth(AdminKey, "ADMIN_KEY", true), filesHandler)
 
-	dst := fmt.Sprintf("./static/%d/%d/%d/%s", now.Year(), int(now.Month()), now.Day(), fileName)
+	// 管理页面，显示图片数量、存储占用、下载最多和最近上传的图片，需要 ADMIN_KEY，浏览器中通过 Basic 认证输入
+	router.GET("/admin", adminPageAuth(), adminHandler)
 
-	err = context.SaveUploadedFile(upload, dst)
+	// 清空缩放、裁剪、转换格式后的图片缓存，需要 ADMIN_KEY
+	router.POST("/admin/cache/purge", adminAuth(), purgeCacheHandler)
+	go loadVariantCacheSize()
+
+	// tus 协议断点续传，上传完成后通过 GET /files/:id 获取图片地址
+	tus := router.Group("/files", tusMiddleware, userAuth(APIKey, "API_KEY", false))
+	tus.OPTIONS("/", tusOptionsHandler)
+	tus.POST("/", uploadLimit, rejectWhenDiskFull, tusCreateHandler)
+	tus.HEAD("/:id", tusHeadHandler)
+	tus.PATCH("/:id", rejectWhenDiskFull, tusPatchHandler)
+	tus.DELETE("/:id", tusDeleteHandler)
+	tus.GET("/:id", tusResultHandler)
+	go cleanupTusUploads()
+
+	// 删除已经过期的图片
+	go cleanupExpiredImages()
+
+	// 定期检查磁盘剩余空间，空间不足时拒绝上传
+	go monitorDiskSpace()
+
+	slog.Info("server started", "port", Port, "tls_mode", TLSMode)
+	err = serve(router)
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		fatal("server stopped", "error", err)
 	}
-	context.JSON(http.StatusOK,
-		gin.H{
-			"message": "图片上传成功！",
-			"data": gin.H{
-				"name": fileName,
-				"url":  Url + dst[1:],
-			},
-		},
-	)
 }
 
 func indexHandler(context *gin.Context) {
@@ -174,7 +208,7 @@ func htmlHandler(context *gin.Context) {
 	defer func(file fs.File) {
 		err := file.Close()
 		if err != nil {
-			panic(err)
+			slog.ErrorContext(context, "failed to close file", "error", err)
 		}
 	}(file)
 
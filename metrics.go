@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+var (
+	// uploadsTotal 上传请求数，status 为 success 或 error
+	uploadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "drawing_bed_uploads_total",
+		Help: "Total number of upload requests by result.",
+	}, []string{"status"})
+
+	// uploadBytesTotal 保存的图片总字节数
+	uploadBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "drawing_bed_upload_bytes_total",
+		Help: "Total number of bytes stored by uploads.",
+	})
+
+	// uploadDuration 上传请求的处理时间
+	uploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "drawing_bed_upload_duration_seconds",
+		Help:    "Time spent handling upload requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// activeRequests 正在处理的请求数
+	activeRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "drawing_bed_active_requests",
+		Help: "Number of requests currently being handled.",
+	})
+)
+
+// metricsHandler 以 Prometheus 格式输出指标
+var metricsHandler = gin.WrapH(promhttp.Handler())
+
+// activeRequestsMetrics 统计正在处理的请求数
+func activeRequestsMetrics(context *gin.Context) {
+	activeRequests.Inc()
+	defer activeRequests.Dec()
+	context.Next()
+}
+
+// uploadMetrics 统计上传请求的结果和处理时间
+func uploadMetrics(context *gin.Context) {
+	start := time.Now()
+	context.Next()
+
+	uploadDuration.Observe(time.Since(start).Seconds())
+	status := "success"
+	if context.Writer.Status() >= http.StatusBadRequest {
+		status = "error"
+	}
+	uploadsTotal.WithLabelValues(status).Inc()
+}
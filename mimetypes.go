@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/matchers"
+	"github.com/h2non/filetype/matchers/isobmff"
+	"github.com/h2non/filetype/types"
+	"path"
+	"slices"
+	"strings"
+)
+
+// svgType filetype 不能识别 SVG，这里根据文件开头的标签判断
+var svgType = types.NewType("svg", "image/svg+xml")
+
+// avifType filetype 不能识别 AVIF，这里根据 ftyp box 中的品牌判断
+var avifType = types.NewType("avif", "image/avif")
+
+// heifType 与 filetype 内置的 HEIF 类型相同。内置的判断只识别 heic 品牌，iPhone 连拍、HEVC 序列等使用的
+// heix、hevc 等品牌需要这里的判断
+var heifType = types.NewType("heif", "image/heif")
+
+// extraType filetype 不能识别或者识别不全的类型
+type extraType struct {
+	kind  types.Type
+	match func(head []byte) bool
+}
+
+// extraTypes ALLOWED_EXTRA_TYPES 中可以允许上传的类型，键为 normalizeType 之后的扩展名
+var extraTypes = map[string]extraType{
+	"svg":  {svgType, isSVG},
+	"heif": {heifType, isHEIF},
+	"avif": {avifType, isAVIF},
+}
+
+// heifBrands HEIC/HEIF 图片 ftyp box 中的品牌
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs"}
+
+// avifBrands AVIF 图片和图片序列 ftyp box 中的品牌
+var avifBrands = []string{"avif", "avis"}
+
+func init() {
+	// 自定义的判断会排在内置判断之前，filetype.Match 能识别这些类型，是否允许上传由 ALLOWED_EXTRA_TYPES 决定
+	for _, t := range extraTypes {
+		filetype.AddMatcher(t.kind, t.match)
+	}
+}
+
+// allowExtraType 把 ALLOWED_EXTRA_TYPES 中的类型 name 加入 filetype 的图片类型，之后 filetype.IsImage 对它返回 true。
+// svg 与开启 ALLOW_SVG 相同，上传时同样会清理
+func allowExtraType(name string) error {
+	t, ok := extraTypes[normalizeType(name)]
+	if !ok {
+		return fmt.Errorf("unsupported extra type %q, expected svg, heic or avif", name)
+	}
+	matchers.Image[t.kind] = t.match
+	if t.kind == svgType {
+		AllowSVG = true
+	}
+	return nil
+}
+
+// isHEIF 文件开头的 ftyp box 是否是 HEIC/HEIF 图片，主品牌为 mif1、msf1 时查看兼容品牌
+func isHEIF(head []byte) bool {
+	return hasFtypBrand(head, heifBrands)
+}
+
+// isAVIF 文件开头的 ftyp box 是否是 AVIF 图片，主品牌为 mif1、msf1 时查看兼容品牌
+func isAVIF(head []byte) bool {
+	return hasFtypBrand(head, avifBrands)
+}
+
+// hasFtypBrand ISO 基础媒体文件格式的 ftyp box 的主品牌是否属于 brands，
+// 主品牌是通用的 mif1 或 msf1 时兼容品牌中是否有属于 brands 的品牌
+func hasFtypBrand(head []byte, brands []string) bool {
+	if !isobmff.IsISOBMFF(head) {
+		return false
+	}
+	major, _, compatible := isobmff.GetFtyp(head)
+	if slices.Contains(brands, major) {
+		return true
+	}
+	if major != "mif1" && major != "msf1" {
+		return false
+	}
+	for _, brand := range compatible {
+		if slices.Contains(brands, brand) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSVG 文件开头（允许 BOM、XML 声明、注释和 DOCTYPE）是否是 svg 标签
+func isSVG(head []byte) bool {
+	head = bytes.ToLower(bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))))
+	if bytes.HasPrefix(head, []byte("<svg")) {
+		return true
+	}
+	return (bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<!"))) &&
+		bytes.Contains(head, []byte("<svg"))
+}
+
+// allowedType 判断文件头 head 对应的类型是否允许上传。
+// 配置了 ALLOWED_MIME_TYPES 时按其中的通配符匹配 MIME 类型，否则只允许 filetype 能识别的图片，
+// 开启 ALLOW_SVG 时还允许 SVG
+func allowedType(head []byte) (types.Type, bool) {
+	kind, _ := filetype.Match(head)
+	if kind == svgType && AllowSVG {
+		return kind, true
+	}
+	if AllowedMIMETypes == nil {
+		return kind, filetype.IsImage(head)
+	}
+	if kind == types.Unknown {
+		return kind, false
+	}
+	for _, pattern := range AllowedMIMETypes {
+		if ok, _ := path.Match(pattern, kind.MIME.Value); ok {
+			return kind, true
+		}
+	}
+	return kind, false
+}
+
+// isImageType kind 是否是图片，只有图片会检查宽高和生成缩略图
+func isImageType(kind types.Type) bool {
+	return kind.MIME.Type == "image" && kind != svgType
+}
+
+// notAllowedTypeMessage 文件类型不允许上传时的错误提示
+func notAllowedTypeMessage() string {
+	if AllowedMIMETypes == nil {
+		return "仅允许上传图片类型！"
+	}
+	return fmt.Sprintf("仅允许上传以下类型：%s！", strings.Join(AllowedMIMETypes, ", "))
+}
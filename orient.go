@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/disintegration/imaging"
+	"image"
+	"image/jpeg"
+)
+
+// exifHeader APP1 段中 EXIF 数据的标识
+var exifHeader = []byte("Exif\x00\x00")
+
+// orientationTag EXIF 中 Orientation 标签的编号
+const orientationTag = 0x0112
+
+// orientTransform 把某个 Orientation 的图片转换为正常方向的操作，name 用于日志
+type orientTransform struct {
+	name  string
+	apply func(image.Image) *image.NRGBA
+}
+
+// orientTransforms 把 Orientation 为 2 到 8 的图片转换为正常方向
+var orientTransforms = map[uint16]orientTransform{
+	2: {"flip horizontal", imaging.FlipH},
+	3: {"rotate 180", imaging.Rotate180},
+	4: {"flip vertical", imaging.FlipV},
+	5: {"transpose", imaging.Transpose},
+	6: {"rotate 90 clockwise", imaging.Rotate270},
+	7: {"transverse", imaging.Transverse},
+	8: {"rotate 90 counterclockwise", imaging.Rotate90},
+}
+
+// autoOrient 按 EXIF 中的 Orientation 旋转或翻转 JPEG 的像素并重新编码，返回处理后的内容以及执行的操作名称。
+// 原图中的 EXIF、XMP、ICC 等元数据会保留，其中的 Orientation 改为 1，之后是否删除由 EXIF_MODE 决定。
+// 不是 JPEG、没有 Orientation 或者已经是正常方向时原样返回，操作名称为空
+func autoOrient(data []byte) ([]byte, string, error) {
+	orientation, metadata := jpegOrientation(data)
+	transform, ok := orientTransforms[orientation]
+	if !ok {
+		return data, "", nil
+	}
+
+	if _, err := checkDecodePixels(bytes.NewReader(data)); err != nil {
+		return nil, "", err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, transform.apply(img), &jpeg.Options{Quality: jpegQuality()})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 在重新编码的图片的 SOI 之后插入原图的元数据段
+	encoded := buf.Bytes()
+	out := make([]byte, 0, len(encoded)+len(metadata))
+	out = append(out, encoded[:2]...)
+	out = append(out, metadata...)
+	out = append(out, encoded[2:]...)
+	return out, transform.name, nil
+}
+
+// jpegOrientation 读取 JPEG 中 EXIF 的 Orientation，没有时返回 0。
+// 同时返回原图中 APP1（EXIF、XMP）、APP2（ICC）、APP13（IPTC）和注释段的副本，其中的 Orientation 已经改为 1
+func jpegOrientation(data []byte) (uint16, []byte) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, nil
+	}
+
+	var orientation uint16
+	var metadata []byte
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) {
+			return 0, nil
+		}
+		if marker == 0xE1 || marker == 0xE2 || marker == 0xED || marker == 0xFE {
+			segment := append([]byte(nil), data[i:end]...)
+			if marker == 0xE1 && bytes.HasPrefix(segment[4:], exifHeader) {
+				if o := resetOrientation(segment[4+len(exifHeader):]); o != 0 {
+					orientation = o
+				}
+			}
+			metadata = append(metadata, segment...)
+		}
+		i = end
+	}
+	return orientation, metadata
+}
+
+// resetOrientation 在 TIFF 格式的 EXIF 数据 tiff 中查找 IFD0 的 Orientation，找到时改为 1 并返回原来的值
+func resetOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for k := 0; k < count; k++ {
+		entry := ifd + 2 + 12*k
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == orientationTag {
+			// SHORT 类型的值保存在值字段的前两个字节
+			orientation := order.Uint16(tiff[entry+8 : entry+10])
+			order.PutUint16(tiff[entry+8:entry+10], 1)
+			return orientation
+		}
+	}
+	return 0
+}
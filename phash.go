@@ -0,0 +1,57 @@
+package main
+
+import (
+	"cmp"
+	"github.com/corona10/goimagehash"
+	"image"
+	"math/bits"
+	"slices"
+)
+
+// maxSimilarImages 上传结果中最多返回的相似图片数量
+const maxSimilarImages = 5
+
+// similarImage 与上传的图片感知哈希相近的已上传图片
+type similarImage struct {
+	Path     string `json:"path"`
+	Distance int    `json:"distance"`
+}
+
+// perceptualHash 计算图片 64 位的 DCT 感知哈希，压缩质量或者轻微修改不同的图片哈希也相近
+func perceptualHash(img image.Image) (uint64, bool) {
+	hash, err := goimagehash.PerceptionHash(img)
+	if err != nil {
+		return 0, false
+	}
+	return hash.GetHash(), true
+}
+
+// findSimilarImages 查找感知哈希与 hash 的汉明距离不超过 PHashThreshold 的图片，不包括 exclude，
+// 按距离从近到远最多返回 maxSimilarImages 个。SQLite 不能计算汉明距离，需要读取所有哈希逐个比较
+func findSimilarImages(hash uint64, exclude string) ([]similarImage, error) {
+	rows, err := db.Query(`SELECT stored_path, phash FROM images WHERE phash IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []similarImage{}
+	for rows.Next() {
+		var path string
+		var stored int64
+		if err := rows.Scan(&path, &stored); err != nil {
+			return nil, err
+		}
+		distance := bits.OnesCount64(hash ^ uint64(stored))
+		if distance <= PHashThreshold && path != exclude {
+			similar = append(similar, similarImage{Path: path, Distance: distance})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(similar, func(a, b similarImage) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return similar[:min(len(similar), maxSimilarImages)], nil
+}
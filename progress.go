@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// progressTTL 上传进度在最后一次更新后保留的时间
+const progressTTL = 10 * time.Minute
+
+// uploadIDPattern X-Upload-Id 允许的格式
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// uploadProgress 一次上传的进度
+type uploadProgress struct {
+	mu       sync.Mutex
+	received int64
+	total    int64
+	// status 为 uploading、done 或 failed
+	status  string
+	updated time.Time
+	// changed 进度更新时关闭并替换，用于通知等待中的 SSE 连接
+	changed chan struct{}
+}
+
+// progressTracker 按 X-Upload-Id 保存上传进度
+type progressTracker struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadProgress
+}
+
+var uploadProgresses = newProgressTracker()
+
+func newProgressTracker() *progressTracker {
+	t := &progressTracker{uploads: map[string]*uploadProgress{}}
+	go func() {
+		for range time.Tick(time.Minute) {
+			t.cleanup(progressTTL)
+		}
+	}()
+	return t
+}
+
+// get 返回 id 对应的进度，不存在时创建，客户端可以在开始上传之前订阅
+func (t *progressTracker) get(id string) *uploadProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.uploads[id]
+	if !ok {
+		p = &uploadProgress{status: "uploading", updated: time.Now(), changed: make(chan struct{})}
+		t.uploads[id] = p
+	}
+	return p
+}
+
+// cleanup 删除超过 idle 时间没有更新的进度，避免放弃的上传占用内存
+func (t *progressTracker) cleanup(idle time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, p := range t.uploads {
+		p.mu.Lock()
+		expired := time.Since(p.updated) > idle
+		p.mu.Unlock()
+		if expired {
+			delete(t.uploads, id)
+		}
+	}
+}
+
+// update 在锁内修改进度并通知订阅者
+func (p *uploadProgress) update(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn()
+	p.updated = time.Now()
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// snapshot 返回当前进度以及下一次更新时会关闭的 channel
+func (p *uploadProgress) snapshot() (gin.H, bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	percent := 0.0
+	if p.total > 0 {
+		percent = float64(p.received) * 100 / float64(p.total)
+	}
+	event := gin.H{"received": p.received, "total": p.total, "percent": percent, "status": p.status}
+	return event, p.status != "uploading", p.changed
+}
+
+// progressReader 读取请求体时更新进度
+type progressReader struct {
+	io.ReadCloser
+	progress *uploadProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		r.progress.update(func() { r.progress.received += int64(n) })
+	}
+	return n, err
+}
+
+// trackProgress 请求头带有 X-Upload-Id 时记录读取请求体的进度
+func trackProgress(context *gin.Context) {
+	id := context.GetHeader("X-Upload-Id")
+	if id == "" {
+		context.Next()
+		return
+	}
+	if !uploadIDPattern.MatchString(id) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "X-Upload-Id 无效！"})
+		return
+	}
+
+	progress := uploadProgresses.get(id)
+	progress.update(func() {
+		progress.received = 0
+		progress.total = context.Request.ContentLength
+		progress.status = "uploading"
+	})
+	context.Request.Body = &progressReader{ReadCloser: context.Request.Body, progress: progress}
+
+	context.Next()
+
+	progress.update(func() {
+		if context.Writer.Status() >= http.StatusBadRequest {
+			progress.status = "failed"
+		} else {
+			progress.status = "done"
+		}
+	})
+}
+
+// progressHandler 通过 Server-Sent Events 推送 /upload/progress/:id 的上传进度，上传结束后关闭连接
+func progressHandler(context *gin.Context) {
+	id := context.Param("id")
+	if !uploadIDPattern.MatchString(id) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "上传 ID 无效！"})
+		return
+	}
+	progress := uploadProgresses.get(id)
+
+	timeout := time.NewTimer(progressTTL)
+	defer timeout.Stop()
+	context.Stream(func(w io.Writer) bool {
+		event, finished, changed := progress.snapshot()
+		context.SSEvent("progress", event)
+		if finished {
+			return false
+		}
+		select {
+		case <-changed:
+			// 限制推送频率
+			time.Sleep(200 * time.Millisecond)
+			return true
+		case <-timeout.C:
+			return false
+		case <-context.Request.Context().Done():
+			return false
+		}
+	})
+}
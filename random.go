@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// randomHandler 从上传记录中随机返回一张图片的名称和公开地址，?mime=image/jpeg 只从该类型的图片中选择，
+// 没有图片时返回 404
+func randomHandler(context *gin.Context) {
+	mime := strings.ToLower(strings.TrimSpace(context.Query("mime")))
+	stored, err := randomImage(mime)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if stored == "" {
+		context.JSON(http.StatusNotFound, gin.H{"error": "没有图片！"})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"url": Storage.URL(stored), "name": path.Base(stored)})
+}
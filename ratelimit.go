@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ipRateLimiter 按客户端 IP 限制请求频率，每个 IP 一个令牌桶
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*ipLimiter
+	limit    rate.Limit
+	burst    int
+}
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPRateLimiter 创建每个 IP 每分钟最多 perMinute 次请求的限流器，并定期清理不活跃的 IP
+func newIPRateLimiter(perMinute int) *ipRateLimiter {
+	l := &ipRateLimiter{
+		limiters: map[string]*ipLimiter{},
+		limit:    rate.Limit(float64(perMinute) / 60),
+		burst:    perMinute,
+	}
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			l.cleanup(5 * time.Minute)
+		}
+	}()
+	return l
+}
+
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.limiters[ip]
+	if !ok {
+		entry = &ipLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[ip] = entry
+	}
+	entry.lastSeen = time.Now()
+	return entry.limiter
+}
+
+// cleanup 删除超过 idle 时间没有请求的 IP，避免占用的内存无限增长
+func (l *ipRateLimiter) cleanup(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ip, entry := range l.limiters {
+		if time.Since(entry.lastSeen) > idle {
+			delete(l.limiters, ip)
+		}
+	}
+}
+
+// rateLimit 超过频率限制时返回 429，并通过 Retry-After 告知多少秒后可以重试。perMinute 为 0 时不限制
+func rateLimit(perMinute int) gin.HandlerFunc {
+	if perMinute <= 0 {
+		return func(context *gin.Context) {
+			context.Next()
+		}
+	}
+
+	limiter := newIPRateLimiter(perMinute)
+	return func(context *gin.Context) {
+		reservation := limiter.get(context.ClientIP()).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			// 不占用令牌，下次请求重新计算
+			reservation.Cancel()
+			context.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			context.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "上传过于频繁，请稍后再试！"})
+			return
+		}
+		context.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// rawHandler 直接把请求体作为图片保存，例如 curl -T a.png http://host/upload/raw/a.png
+func rawHandler(context *gin.Context) {
+	if context.Request.ContentLength > maxUploadSize() {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fileTooLargeMessage()})
+		return
+	}
+
+	// Content-Length 可能缺失或者与实际不符，复制时仍然限制最多读取 maxUploadSize()
+	body := http.MaxBytesReader(context.Writer, context.Request.Body, maxUploadSize())
+
+	checksums, uploadErr := requestChecksums(context)
+	if uploadErr != nil {
+		respondUploadError(context, uploadErr)
+		return
+	}
+
+	fileName := context.Param("filename")
+	result, uploadErr := saveImage(context, fileName, withChecksums(body, checksums), context.Request.ContentLength, requestUploadOptions(context))
+	if uploadErr != nil {
+		respondUploadError(context, uploadErr)
+		return
+	}
+	context.JSON(http.StatusOK,
+		gin.H{
+			"message": "图片上传成功！",
+			"data":    result,
+		},
+	)
+}
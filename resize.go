@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/jpegli"
+	"github.com/gen2brain/webp"
+	"image"
+	"io"
+	"log/slog"
+)
+
+// resizableFormats 支持缩放的图片格式，GIF、WebP 等格式原样保存
+var resizableFormats = map[string]imaging.Format{
+	"jpg": imaging.JPEG,
+	"png": imaging.PNG,
+	"bmp": imaging.BMP,
+}
+
+// convertibleFormats 配置了 ConvertTo 时会转换格式的图片格式
+var convertibleFormats = map[string]bool{
+	"jpg": true,
+	"png": true,
+}
+
+// needsResize 是否配置了最大宽高并且 ext 对应的格式支持缩放
+func needsResize(ext string) bool {
+	_, ok := resizableFormats[ext]
+	return ok && (MaxImageWidth > 0 || MaxImageHeight > 0)
+}
+
+// needsConversion 是否需要把 ext 格式的图片转换为 ConvertTo 格式
+func needsConversion(ext string) bool {
+	return ConvertTo != "" && convertibleFormats[ext]
+}
+
+// needsRecompression 是否需要按 JPEGQuality 重新压缩 ext 格式的图片，只有 JPEG 会重新压缩
+func needsRecompression(ext string) bool {
+	return ext == "jpg" && JPEGQuality > 0
+}
+
+// needsTransform 是否需要解码后重新编码 ext 格式的图片，watermark 为 false 表示不添加水印
+func needsTransform(ext string, watermark bool) bool {
+	return needsResize(ext) || needsWatermark(ext, watermark) || needsConversion(ext) || needsRecompression(ext)
+}
+
+// transformImage 按需缩小图片、添加水印、转换为 WebP 或 AVIF 或者重新压缩 JPEG，返回处理后的内容和扩展名。
+// 不需要处理，或者只是重新压缩、转换格式但结果没有变小时原样返回 data 和 ext。
+// 小于 JPEGRecompressMinSize 的 JPEG 不重新压缩，AVIF 编码超时时保留原来的格式，watermark 为 false 表示不添加水印
+func transformImage(data []byte, ext string, watermark bool) ([]byte, string, error) {
+	recompress := needsRecompression(ext) && int64(len(data)) >= JPEGRecompressMinSize
+	if !recompress && !needsResize(ext) && !needsWatermark(ext, watermark) && !needsConversion(ext) {
+		return data, ext, nil
+	}
+
+	img, _, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	resized := false
+	if needsResize(ext) {
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		maxWidth, maxHeight := MaxImageWidth, MaxImageHeight
+		if maxWidth <= 0 {
+			maxWidth = width
+		}
+		if maxHeight <= 0 {
+			maxHeight = height
+		}
+		if width > maxWidth || height > maxHeight {
+			img = imaging.Fit(img, maxWidth, maxHeight, imaging.Lanczos)
+			resized = true
+		}
+	}
+
+	watermarked := false
+	if needsWatermark(ext, watermark) {
+		img, watermarked, err = drawWatermark(img)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	original := ext
+	var buf bytes.Buffer
+	switch {
+	case needsConversion(ext):
+		ext = ConvertTo
+		err = encodeImage(&buf, img, ext)
+		if errors.Is(err, errEncodeTimeout) {
+			slog.Warn("image conversion timed out, keeping the original format", "format", ext, "timeout", AVIFTimeout.String())
+			if !resized && !watermarked {
+				return data, original, nil
+			}
+			ext = original
+			buf.Reset()
+			err = encodeImage(&buf, img, ext)
+		}
+	case resized || watermarked || recompress:
+		err = encodeImage(&buf, img, ext)
+	default:
+		return data, ext, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !resized && !watermarked && buf.Len() >= len(data) {
+		// 原图已经压缩得足够小，重新压缩或者转换为 WebP 只会变大
+		return data, original, nil
+	}
+	return buf.Bytes(), ext, nil
+}
+
+// encodeImage 按 ext 对应的格式编码图片
+func encodeImage(w io.Writer, img image.Image, ext string) error {
+	switch ext {
+	case "webp":
+		return webp.Encode(w, img, webp.Options{Quality: WebPQuality, Method: webp.DefaultMethod})
+	case "avif":
+		return encodeAVIF(w, img)
+	case "jpg":
+		if JPEGQuality > 0 {
+			return encodeProgressiveJPEG(w, img)
+		}
+	}
+	format, ok := resizableFormats[ext]
+	if !ok {
+		return fmt.Errorf("unsupported image format: %s", ext)
+	}
+	return imaging.Encode(w, img, format, imaging.JPEGQuality(jpegQuality()))
+}
+
+// encodeProgressiveJPEG 按 JPEGQuality 把图片编码为渐进式 JPEG，通常比基线 JPEG 更小，加载时也能先显示轮廓
+func encodeProgressiveJPEG(w io.Writer, img image.Image) error {
+	return jpegli.Encode(w, img, &jpegli.EncodingOptions{
+		Quality:              JPEGQuality,
+		ChromaSubsampling:    image.YCbCrSubsampleRatio420,
+		ProgressiveLevel:     2,
+		OptimizeCoding:       true,
+		AdaptiveQuantization: true,
+	})
+}
+
+// jpegQuality 重新编码 JPEG 时使用的质量，没有配置 JPEGQuality 时使用 90
+func jpegQuality() int {
+	if JPEGQuality > 0 {
+		return JPEGQuality
+	}
+	return 90
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand/v2"
+	"testing"
+)
+
+// samplePhoto 生成一张带有渐变和噪点、接近照片的 800x600 图片
+func samplePhoto() image.Image {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	for y := range 600 {
+		for x := range 800 {
+			noise := rng.IntN(24)
+			img.Set(x, y, color.RGBA{
+				R: uint8(x*255/800) ^ uint8(noise),
+				G: uint8(y*255/600) ^ uint8(noise),
+				B: uint8((x+y)*255/1400) ^ uint8(noise),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// webpSamples 返回用于比较 WebP 转换效果的 JPEG 和 PNG 示例
+func webpSamples(tb testing.TB) map[string][]byte {
+	tb.Helper()
+	img := samplePhoto()
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, &jpeg.Options{Quality: 90}); err != nil {
+		tb.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		tb.Fatal(err)
+	}
+	return map[string][]byte{"jpg": jpg.Bytes(), "png": pngData.Bytes()}
+}
+
+// withWebPConversion 在测试期间开启 CONVERT_TO=webp
+func withWebPConversion(tb testing.TB) {
+	oldConvert, oldQuality := ConvertTo, WebPQuality
+	ConvertTo, WebPQuality = "webp", 80
+	tb.Cleanup(func() { ConvertTo, WebPQuality = oldConvert, oldQuality })
+}
+
+func TestWebPConversionSize(t *testing.T) {
+	withWebPConversion(t)
+	for ext, data := range webpSamples(t) {
+		out, newExt, err := transformImage(data, ext, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s: %d bytes -> webp: %d bytes (%.1f%% smaller)",
+			ext, len(data), len(out), 100-float64(len(out))*100/float64(len(data)))
+		if newExt != "webp" {
+			t.Errorf("%s converted to %q, want webp", ext, newExt)
+		}
+		if len(out) >= len(data) {
+			t.Errorf("%s: webp is %d bytes, not smaller than %d", ext, len(out), len(data))
+		}
+	}
+}
+
+func BenchmarkWebPConversion(b *testing.B) {
+	withWebPConversion(b)
+	for ext, data := range webpSamples(b) {
+		b.Run(ext, func(b *testing.B) {
+			var out []byte
+			for b.Loop() {
+				var err error
+				out, _, err = transformImage(data, ext, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "input-bytes")
+			b.ReportMetric(float64(len(out)), "output-bytes")
+			b.ReportMetric(100-float64(len(out))*100/float64(len(data)), "%smaller")
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// securityHeader 每个响应都会带上的安全相关响应头，value 为空时不设置
+type securityHeader struct {
+	name  string
+	value string
+}
+
+// securityHeaders 根据配置返回需要设置的响应头，只有开启 TLS 时才设置 Strict-Transport-Security
+func securityHeaders() []securityHeader {
+	headers := []securityHeader{
+		{"X-Content-Type-Options", ContentTypeOptions},
+		{"X-Frame-Options", FrameOptions},
+		{"Referrer-Policy", ReferrerPolicy},
+		{"Content-Security-Policy", CSPHeader},
+	}
+	if TLSMode != TLSModeOff {
+		headers = append(headers, securityHeader{"Strict-Transport-Security", HSTSHeader})
+	}
+	enabled := headers[:0]
+	for _, h := range headers {
+		if h.value != "" {
+			enabled = append(enabled, h)
+		}
+	}
+	return enabled
+}
+
+// securityHeadersMiddleware 给每个响应加上安全相关的响应头，处理函数可以覆盖，
+// 例如 staticHandler 给 SVG 设置更严格的 Content-Security-Policy
+func securityHeadersMiddleware() gin.HandlerFunc {
+	headers := securityHeaders()
+	return func(context *gin.Context) {
+		for _, h := range headers {
+			context.Header(h.name, h.value)
+		}
+		context.Next()
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	ctx "context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// shuttingDown 收到 SIGTERM 或 SIGINT 之后为 true
+var shuttingDown atomic.Bool
+
+// rejectDuringShutdown 关闭过程中通过已有连接发来的新请求返回 503，已经在处理的请求不受影响
+func rejectDuringShutdown(context *gin.Context) {
+	if shuttingDown.Load() {
+		context.Header("Connection", "close")
+		context.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "服务正在关闭，请稍后重试！"})
+		return
+	}
+	context.Next()
+}
+
+// serve 启动 HTTP 服务（开启 TLS 时同时启动 HTTPS 服务），收到 SIGTERM 或 SIGINT 后停止接受新连接，
+// 最多等待 ShutdownTimeout 让正在处理的请求（例如上传）和已经接受的异步处理任务完成，再关闭数据库后退出
+func serve(handler http.Handler) error {
+	signals, stop := signal.NotifyContext(ctx.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	servers := listeners(handler)
+	errs := make(chan error, len(servers))
+	for _, l := range servers {
+		go func() {
+			errs <- l.listen()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+		// 其中一个服务启动失败时关闭其他服务后退出
+	case <-signals.Done():
+	}
+
+	shuttingDown.Store(true)
+	slog.Info("shutting down", "timeout", ShutdownTimeout.String())
+	timeout, cancel := ctx.WithTimeout(ctx.Background(), ShutdownTimeout)
+	defer cancel()
+	for _, l := range servers {
+		if shutdownErr := l.server.Shutdown(timeout); shutdownErr != nil {
+			slog.Error("shutdown did not complete, in-flight requests were aborted", "addr", l.server.Addr, "error", shutdownErr)
+			return shutdownErr
+		}
+	}
+	// 任务完成时还要保存图片和更新上传记录，必须在关闭数据库之前处理完
+	if jobQueue != nil {
+		if drainErr := jobQueue.drain(timeout); drainErr != nil {
+			slog.Error("processing jobs did not complete before shutdown", "error", drainErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for range servers {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	if err := shutdownTracing(timeout); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+	slog.Info("shutdown complete")
+	return db.Close()
+}
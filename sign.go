@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staticServer 提供 ./static 目录下的文件，不列出目录
+var staticServer = http.StripPrefix("/static", http.FileServer(gin.Dir(staticDir, false)))
+
+// signHandler 为 /sign/*path 生成带有效期的图片地址，?ttl= 为有效秒数，默认 3600
+func signHandler(context *gin.Context) {
+	if URLSigningSecret == "" {
+		context.JSON(http.StatusForbidden, gin.H{"error": "请先配置 URL_SIGNING_SECRET！"})
+		return
+	}
+	if _, ok := Storage.(*LocalBackend); !ok {
+		context.JSON(http.StatusNotImplemented, gin.H{"error": "当前存储后端不支持签名地址！"})
+		return
+	}
+
+	path := strings.TrimPrefix(context.Param("path"), "/")
+	if path == "" {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效！"})
+		return
+	}
+	ttl, err := strconv.ParseInt(context.DefaultQuery("ttl", "3600"), 10, 64)
+	if err != nil || ttl <= 0 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "ttl 必须是正整数！"})
+		return
+	}
+
+	exists, err := Storage.Exists(path)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return
+	}
+
+	expires := time.Now().Unix() + ttl
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("token", signPath(path, expires))
+	context.JSON(http.StatusOK, gin.H{
+		"url":     Storage.URL(path) + "?" + query.Encode(),
+		"expires": expires,
+	})
+}
+
+// staticHandler 提供已上传的图片并统计下载次数。带有 token 的请求会校验签名和有效期，
+// 开启 REQUIRE_SIGNED_URLS 时没有签名的请求返回 403，带有 ?w=&h=&fit= 时返回缩放后的图片
+func staticHandler(context *gin.Context) {
+	if !checkImageAccess(context, strings.TrimPrefix(context.Param("filepath"), "/")) {
+		return
+	}
+
+	// SVG 中可以包含脚本，禁止它在本站的页面中执行
+	if strings.HasSuffix(strings.ToLower(context.Param("filepath")), ".svg") {
+		context.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	}
+	// 图片保存在对象存储中时跳转到对象存储的地址，不经过本地磁盘
+	if _, local := Storage.(*LocalBackend); !local {
+		context.Redirect(http.StatusFound, Storage.URL(strings.TrimPrefix(context.Param("filepath"), "/")))
+		return
+	}
+	// 带有 w、h 参数时返回缩放后的版本
+	if wantsVariant(context) {
+		serveVariant(context, strings.TrimPrefix(context.Param("filepath"), "/"))
+	} else {
+		staticServer.ServeHTTP(context.Writer, context.Request)
+	}
+
+	if context.Request.Method == http.MethodGet && context.Writer.Status() == http.StatusOK {
+		path := strings.TrimPrefix(context.Param("filepath"), "/")
+		if err := recordDownload(path); err != nil {
+			slog.ErrorContext(context, "failed to record download", "path", path, "error", err)
+		}
+	}
+}
+
+// inResizeCache 判断 ./static 下的 path 是否位于 ResizeCacheDir 中，缓存文件只能通过 ?w=&h= 等参数访问
+func inResizeCache(path string) bool {
+	rel, err := filepath.Rel(staticDir, ResizeCacheDir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	clean := filepath.Clean(filepath.FromSlash("/" + path))[1:]
+	return clean == rel || strings.HasPrefix(clean, rel+string(filepath.Separator))
+}
+
+// checkImageAccess 校验访问图片 path 的签名和有效期，不能访问时返回错误响应并返回 false。
+// 带有 token 的请求会校验签名，开启 REQUIRE_SIGNED_URLS 时必须带有签名，设置了有效期的图片过期后返回 410，
+// 缩放缓存中的文件返回 404
+func checkImageAccess(context *gin.Context, path string) bool {
+	if inResizeCache(path) {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return false
+	}
+	token := context.Query("token")
+	if token != "" || RequireSignedURLs {
+		expires, err := strconv.ParseInt(context.Query("expires"), 10, 64)
+		if err != nil || !validSignature(path, expires, token) {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "链接无效或已过期！"})
+			return false
+		}
+	}
+	expired, err := isExpired(path)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if expired {
+		context.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "图片已过期！"})
+		return false
+	}
+	return true
+}
+
+// signPath 计算 path 和过期时间 expires 的 HMAC-SHA256 签名
+func signPath(path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(URLSigningSecret))
+	mac.Write([]byte(path + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature 校验签名是否正确并且没有过期
+func validSignature(path string, expires int64, token string) bool {
+	if URLSigningSecret == "" || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(signPath(path, expires)))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestInResizeCache(t *testing.T) {
+	old := ResizeCacheDir
+	t.Cleanup(func() { ResizeCacheDir = old })
+
+	ResizeCacheDir = "./static/.cache"
+	tests := map[string]bool{
+		".cache":                 true,
+		".cache/1e/abc.png":      true,
+		"./.cache/1e/abc.png":    true,
+		"2026/../.cache/abc.png": true,
+		"/.cache/abc.png":        true,
+		".cache-photo.png":       false,
+		"2026/10/15/.cache":      false,
+		"2026/10/15/photo.png":   false,
+		"thumbs/2026/photo.png":  false,
+	}
+	for path, want := range tests {
+		if got := inResizeCache(path); got != want {
+			t.Errorf("inResizeCache(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	// 缓存目录不在 ./static 下时不影响访问
+	ResizeCacheDir = "./data/resized"
+	if inResizeCache(".cache/abc.png") {
+		t.Error("inResizeCache matched a path outside the cache directory")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imageStat 图片的访问统计
+type imageStat struct {
+	Path          string     `json:"path"`
+	DownloadCount int64      `json:"download_count"`
+	UploadTime    *time.Time `json:"upload_time"`
+}
+
+// recordUpload 记录图片 path 的上传时间
+func recordUpload(path string) error {
+	_, err := db.Exec(`INSERT INTO image_stats (path, upload_time) VALUES (?, ?)
+		ON CONFLICT (path) DO UPDATE SET upload_time = excluded.upload_time`, path, time.Now().Unix())
+	return err
+}
+
+// recordDownload 图片 path 的下载次数加一
+func recordDownload(path string) error {
+	_, err := db.Exec(`INSERT INTO image_stats (path, download_count) VALUES (?, 1)
+		ON CONFLICT (path) DO UPDATE SET download_count = download_count + 1`, path)
+	return err
+}
+
+// statsHandler 处理 /stats/*path，/stats/top 返回下载次数最多的图片，/stats/cache 返回图片缓存的大小，其它路径返回对应图片的统计
+func statsHandler(context *gin.Context) {
+	path := strings.TrimPrefix(context.Param("path"), "/")
+	switch path {
+	case "top":
+		topStatsHandler(context)
+		return
+	case "cache":
+		cacheStatsHandler(context)
+		return
+	}
+
+	stat, err := queryStat(path)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 统计之前上传的图片没有记录
+		exists, existsErr := Storage.Exists(path)
+		if existsErr != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": existsErr.Error()})
+			return
+		}
+		if !exists {
+			context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+			return
+		}
+		stat, err = &imageStat{Path: path}, nil
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, stat)
+}
+
+// topStatsHandler 返回下载次数最多的 n 张图片，?n= 默认为 10，最大为 100
+func topStatsHandler(context *gin.Context) {
+	n, err := strconv.Atoi(context.DefaultQuery("n", "10"))
+	if err != nil || n < 1 || n > 100 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "n 必须在 1 到 100 之间！"})
+		return
+	}
+
+	stats, err := topStats(n)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+// topStats 查询下载次数最多的 n 张图片
+func topStats(n int) ([]*imageStat, error) {
+	rows, err := db.Query(`SELECT path, download_count, upload_time FROM image_stats
+		ORDER BY download_count DESC, path LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*imageStat{}
+	for rows.Next() {
+		stat, err := scanStat(rows)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// queryStat 查询图片 path 的统计，没有记录时返回 sql.ErrNoRows
+func queryStat(path string) (*imageStat, error) {
+	row := db.QueryRow(`SELECT path, download_count, upload_time FROM image_stats WHERE path = ?`, path)
+	return scanStat(row)
+}
+
+// scanStat 读取一行 path, download_count, upload_time
+func scanStat(row interface{ Scan(...any) error }) (*imageStat, error) {
+	var stat imageStat
+	var uploadTime sql.NullInt64
+	err := row.Scan(&stat.Path, &stat.DownloadCount, &uploadTime)
+	if err != nil {
+		return nil, err
+	}
+	if uploadTime.Valid {
+		t := time.Unix(uploadTime.Int64, 0)
+		stat.UploadTime = &t
+	}
+	return &stat, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// StorageBackend 文件存储后端，path 为使用 / 分隔的相对路径，例如 2023/9/1/a.png
+type StorageBackend interface {
+	// Save 将 r 中的内容保存到 path，size 为内容的大小，未知时为 -1
+	Save(path string, r io.Reader, size int64) error
+	// URL 返回 path 对应的访问地址
+	URL(path string) string
+	// Exists 判断 path 是否已经存在
+	Exists(path string) (bool, error)
+	// Delete 删除 path
+	Delete(path string) error
+}
+
+// NewStorageBackend 根据 STORAGE_BACKEND 环境变量的值创建存储后端，默认为本地磁盘
+func NewStorageBackend(name string) (StorageBackend, error) {
+	switch name {
+	case "", "local":
+		return NewLocalBackend(staticDir, Url), nil
+	case "s3":
+		return NewS3BackendFromEnv()
+	case "oss":
+		return NewOSSBackendFromEnv()
+	case "cos":
+		return NewCOSBackendFromEnv()
+	case "kodo", "qiniu":
+		return NewKodoBackendFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", name)
+	}
+}
+
+// contentType 按扩展名返回保存到对象存储时的 Content-Type，无法识别时使用 application/octet-stream
+func contentType(name string) string {
+	ext := normalizeType(strings.TrimPrefix(path.Ext(name), "."))
+	if mime := mimeType(ext); mime != "" {
+		return mime
+	}
+	return "application/octet-stream"
+}
+
+// storageError 对象存储返回的错误，Code 为 S3 或 OSS 的错误码，例如 AccessDenied、InvalidAccessKeyId
+type storageError struct {
+	Code string
+	err  error
+}
+
+func (e *storageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.err)
+}
+
+func (e *storageError) Unwrap() error {
+	return e.err
+}
+
+// storageErrorStatuses 对象存储错误码对应的上传接口状态码，其它错误码返回 502
+var storageErrorStatuses = map[string]int{
+	// 超过对象存储的单个文件大小限制
+	"EntityTooLarge": http.StatusRequestEntityTooLarge,
+	// 存储空间超过配额，或者阿里云账号欠费停用
+	"QuotaExceeded": http.StatusInsufficientStorage,
+	"UserDisable":   http.StatusInsufficientStorage,
+	// 对象存储暂时不可用或者限流，可以稍后重试
+	"SlowDown":           http.StatusServiceUnavailable,
+	"ServiceUnavailable": http.StatusServiceUnavailable,
+	"InternalError":      http.StatusServiceUnavailable,
+	"RequestTimeout":     http.StatusServiceUnavailable,
+}
+
+// Status 返回上传接口使用的状态码。密钥无效、没有权限、存储桶不存在等配置错误返回 502
+func (e *storageError) Status() int {
+	if status, ok := storageErrorStatuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusBadGateway
+}
+
+// staticDir 本地存储保存图片的目录，通过 /static 路由访问
+const staticDir = "./static"
+
+// LocalBackend 本地磁盘存储，文件通过 /static 路由访问
+type LocalBackend struct {
+	// Root 保存文件的根目录
+	Root string
+	// BaseURL 返回的图片Url前缀
+	BaseURL string
+}
+
+// NewLocalBackend 创建本地磁盘存储
+func NewLocalBackend(root string, baseURL string) *LocalBackend {
+	return &LocalBackend{Root: root, BaseURL: baseURL}
+}
+
+// errOutsideRoot 文件路径不在存储根目录内
+var errOutsideRoot = errors.New("path escapes the storage root")
+
+// resolve 把存储路径转换为磁盘路径，并确认它在 Root 目录内
+func (b *LocalBackend) resolve(path string) (string, error) {
+	root := filepath.Clean(b.Root)
+	dst := filepath.Join(root, filepath.FromSlash(path))
+	rel, err := filepath.Rel(root, dst)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return dst, nil
+}
+
+func (b *LocalBackend) Save(path string, r io.Reader, size int64) error {
+	dst, err := b.resolve(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0750)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, r)
+	if err == nil {
+		// 确保返回成功前内容已经写入磁盘
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// 删除写了一半的文件
+		_ = os.Remove(dst)
+	}
+	return err
+}
+
+func (b *LocalBackend) URL(path string) string {
+	return b.BaseURL + "/static/" + path
+}
+
+func (b *LocalBackend) Exists(path string) (bool, error) {
+	dst, err := b.resolve(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete 删除文件，并删除因此变为空的上级目录
+func (b *LocalBackend) Delete(path string) error {
+	dst, err := b.resolve(path)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(dst)
+	if err != nil {
+		return err
+	}
+	root := filepath.Clean(b.Root)
+	for dir := filepath.Dir(dst); dir != root && dir != "."; dir = filepath.Dir(dir) {
+		// 目录不为空时 Remove 会失败
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
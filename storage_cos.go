@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// COSBackend 腾讯云对象存储，通过 COS 的 S3 兼容接口访问，上传、检查和删除都由 S3Backend 完成。
+// 注意：这里没有使用 cos-go-sdk-v5，构建环境无法下载该模块（模块代理返回 403），需要时再替换
+type COSBackend struct {
+	*S3Backend
+}
+
+// NewCOSBackendFromEnv 根据环境变量创建腾讯云 COS 存储：COS_BUCKET_URL 或者 COS_BUCKET 加 COS_REGION，
+// COS_SECRET_ID、COS_SECRET_KEY、COS_PUBLIC_URL。创建时会检查存储桶能否访问，密钥错误时直接返回错误
+func NewCOSBackendFromEnv() (*COSBackend, error) {
+	bucketURL := strings.TrimSuffix(os.Getenv("COS_BUCKET_URL"), "/")
+	if bucketURL == "" {
+		bucket, region := os.Getenv("COS_BUCKET"), os.Getenv("COS_REGION")
+		if bucket == "" || region == "" {
+			return nil, errors.New("COS_BUCKET_URL, or COS_BUCKET and COS_REGION, are required when STORAGE_BACKEND=cos")
+		}
+		bucketURL = "https://" + bucket + ".cos." + region + ".myqcloud.com"
+	}
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// 存储桶域名的格式为 <bucket>.cos.<region>.myqcloud.com
+	bucket, endpoint, found := strings.Cut(u.Host, ".")
+	if !found || !strings.HasPrefix(endpoint, "cos.") {
+		return nil, fmt.Errorf("invalid COS_BUCKET_URL: %s", bucketURL)
+	}
+	region := strings.TrimSuffix(strings.TrimPrefix(endpoint, "cos."), ".myqcloud.com")
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(os.Getenv("COS_SECRET_ID"), os.Getenv("COS_SECRET_KEY"), ""),
+		Secure:       u.Scheme != "http",
+		Region:       region,
+		BucketLookup: minio.BucketLookupDNS,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &COSBackend{&S3Backend{
+		client:    client,
+		bucket:    bucket,
+		publicURL: u.Scheme + "://" + u.Host,
+	}}
+	// 绑定了 CDN 或自定义域名时使用该域名，只填写域名时使用 https
+	if v := strings.TrimSuffix(os.Getenv("COS_PUBLIC_URL"), "/"); v != "" {
+		backend.publicURL = v
+		if !strings.Contains(v, "://") {
+			backend.publicURL = "https://" + v
+		}
+	}
+	if err := backend.checkBucket("COS"); err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"io"
+	"os"
+	"strings"
+)
+
+// OSSBackend 阿里云对象存储
+type OSSBackend struct {
+	bucket *oss.Bucket
+	// baseURL 公开访问地址，默认为 https://<bucket>.<endpoint>，可以配置为 CDN 域名
+	baseURL string
+}
+
+// NewOSSBackendFromEnv 根据环境变量创建阿里云 OSS 存储：
+// OSS_ENDPOINT、OSS_ACCESS_KEY_ID、OSS_ACCESS_KEY_SECRET、OSS_BUCKET、OSS_PUBLIC_URL
+func NewOSSBackendFromEnv() (*OSSBackend, error) {
+	endpoint := os.Getenv("OSS_ENDPOINT")
+	bucketName := os.Getenv("OSS_BUCKET")
+	if endpoint == "" || bucketName == "" {
+		return nil, errors.New("OSS_ENDPOINT and OSS_BUCKET are required when STORAGE_BACKEND=oss")
+	}
+
+	client, err := oss.New(endpoint, os.Getenv("OSS_ACCESS_KEY_ID"), os.Getenv("OSS_ACCESS_KEY_SECRET"))
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.Bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "https"
+	host := endpoint
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		scheme, host = endpoint[:i], endpoint[i+3:]
+	}
+	baseURL := scheme + "://" + bucketName + "." + strings.TrimSuffix(host, "/")
+	// 绑定了 CDN 或自定义域名时使用该域名，只填写域名时使用 https
+	if v := strings.TrimSuffix(os.Getenv("OSS_PUBLIC_URL"), "/"); v != "" {
+		baseURL = v
+		if !strings.Contains(v, "://") {
+			baseURL = "https://" + v
+		}
+	}
+
+	return &OSSBackend{
+		bucket:  bucket,
+		baseURL: baseURL,
+	}, nil
+}
+
+func (b *OSSBackend) Save(path string, r io.Reader, size int64) error {
+	// SDK 直接把 r 作为请求体发送，不会把整个文件读入内存
+	return ossError(b.bucket.PutObject(path, r, oss.ContentType(contentType(path))))
+}
+
+func (b *OSSBackend) URL(path string) string {
+	return b.baseURL + "/" + path
+}
+
+func (b *OSSBackend) Exists(path string) (bool, error) {
+	exists, err := b.bucket.IsObjectExist(path)
+	return exists, ossError(err)
+}
+
+func (b *OSSBackend) Delete(path string) error {
+	return ossError(b.bucket.DeleteObject(path))
+}
+
+// ossError 把 OSS 返回的错误包装为带有错误码的 storageError，没有错误码（例如网络错误）时原样返回
+func ossError(err error) error {
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.Code != "" {
+		return &storageError{Code: serviceErr.Code, err: err}
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultS3Region 使用自定义 S3_ENDPOINT 且没有配置 S3_REGION 时使用的区域。
+// 区域为空时 minio 会先请求 GetBucketLocation，R2、Backblaze 等服务不支持或返回 AWS 以外的区域名
+const defaultS3Region = "us-east-1"
+
+// bucketCheckTimeout 启动时检查存储桶能否访问的超时时间
+const bucketCheckTimeout = 10 * time.Second
+
+// s3PartSize 分片上传时每个分片的大小。大小未知时 minio 默认按 5 TiB 的对象计算分片大小，
+// 每次上传都会分配约 537 MiB 的缓冲区，因此固定为 16 MiB；大小已知且小于该值时直接使用普通上传
+const s3PartSize = 16 << 20
+
+// S3Backend S3 兼容的对象存储（AWS S3、MinIO、Cloudflare R2、Wasabi、Backblaze B2 等）
+type S3Backend struct {
+	client *minio.Client
+	bucket string
+	// prefix 对象键的前缀，例如 images/，为空时直接使用存储路径
+	prefix string
+	// publicURL 公开访问地址的前缀，默认为 <endpoint>/<bucket>，可以配置为 CloudFront 等 CDN 域名
+	publicURL string
+}
+
+// NewS3BackendFromEnv 根据环境变量创建 S3 存储：
+// AWS_ACCESS_KEY_ID、AWS_SECRET_ACCESS_KEY、S3_BUCKET、S3_ENDPOINT、S3_REGION、S3_FORCE_PATH_STYLE、S3_PREFIX、S3_PUBLIC_URL
+func NewS3BackendFromEnv() (*S3Backend, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET is required when STORAGE_BACKEND=s3")
+	}
+
+	region := os.Getenv("S3_REGION")
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+		if region != "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+	} else if region == "" {
+		region = defaultS3Region
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// MinIO 等自建服务通常只支持 <endpoint>/<bucket> 形式的路径访问，不支持 <bucket>.<endpoint> 形式的域名访问
+	lookup := minio.BucketLookupAuto
+	if os.Getenv("S3_FORCE_PATH_STYLE") == "true" {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), ""),
+		Secure:       u.Scheme == "https",
+		Region:       region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(os.Getenv("S3_PREFIX"), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	publicURL := strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = u.Scheme + "://" + u.Host + "/" + bucket
+	}
+
+	return &S3Backend{
+		client:    client,
+		bucket:    bucket,
+		prefix:    prefix,
+		publicURL: publicURL,
+	}, nil
+}
+
+// key 返回存储路径对应的对象键
+func (b *S3Backend) key(path string) string {
+	return b.prefix + path
+}
+
+func (b *S3Backend) Save(path string, r io.Reader, size int64) error {
+	_, err := b.client.PutObject(context.Background(), b.bucket, b.key(path), r, size, minio.PutObjectOptions{
+		ContentType: contentType(path),
+		PartSize:    s3PartSize,
+	})
+	return s3Error(err)
+}
+
+func (b *S3Backend) URL(path string) string {
+	return b.publicURL + "/" + b.key(path)
+}
+
+func (b *S3Backend) Exists(path string) (bool, error) {
+	_, err := b.client.StatObject(context.Background(), b.bucket, b.key(path), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, s3Error(err)
+	}
+	return true, nil
+}
+
+func (b *S3Backend) Delete(path string) error {
+	return s3Error(b.client.RemoveObject(context.Background(), b.bucket, b.key(path), minio.RemoveObjectOptions{}))
+}
+
+// checkBucket 访问一次存储桶，确认地址和密钥正确，错误信息中带有 service 返回的错误码
+func (b *S3Backend) checkBucket(service string) error {
+	c, cancel := context.WithTimeout(context.Background(), bucketCheckTimeout)
+	defer cancel()
+	exists, err := b.client.BucketExists(c, b.bucket)
+	if err != nil {
+		return fmt.Errorf("cannot access %s bucket %s: %w", service, b.bucket, s3Error(err))
+	}
+	if !exists {
+		return fmt.Errorf("%s bucket %s does not exist at %s", service, b.bucket, b.client.EndpointURL().Host)
+	}
+	return nil
+}
+
+// s3Error 把 minio 返回的错误包装为带有 S3 错误码的 storageError，没有错误码（例如网络错误）时原样返回
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if code := minio.ToErrorResponse(err).Code; code != "" {
+		return &storageError{Code: code, err: err}
+	}
+	return err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 只实现存储后端用到的接口的内存 S3 服务：PutObject、分片上传、HeadObject、DeleteObject
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	parts   map[string][][]byte
+	// requests 收到的请求，格式为 "<方法> <路径>?<参数>"
+	requests []string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{objects: map[string][]byte{}, types: map[string]string{}, parts: map[string][][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+	body, _ := io.ReadAll(r.Body)
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = decodeAWSChunked(body)
+	}
+	key := r.URL.Path
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.parts[key] = nil
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprint(w, `<InitiateMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+			`<Bucket>bucket</Bucket><Key>key</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		f.parts[key] = append(f.parts[key], body)
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, len(f.parts[key])))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.objects[key] = bytes.Join(f.parts[key], nil)
+		delete(f.parts, key)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprint(w, `<CompleteMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+			`<Bucket>bucket</Bucket><Key>key</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.parts, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// decodeAWSChunked 去掉通过 http 上传时 minio 使用的 aws-chunked 编码：<十六进制长度>;chunk-signature=...\r\n<数据>\r\n
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	for len(body) > 0 {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			break
+		}
+		size, _, _ := bytes.Cut(header, []byte(";"))
+		var n int
+		if _, err := fmt.Sscanf(string(size), "%x", &n); err != nil || n == 0 || n > len(rest) {
+			break
+		}
+		out = append(out, rest[:n]...)
+		body = bytes.TrimPrefix(rest[n:], []byte("\r\n"))
+	}
+	return out
+}
+
+// multipartRequests 返回收到的初始化分片上传请求的数量
+func (f *fakeS3) multipartRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, req := range f.requests {
+		if strings.HasSuffix(req, "?uploads=") {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestS3Backend 通过环境变量创建指向 server 的 S3 存储，与 MinIO、R2 等自定义地址的配置方式相同
+func newTestS3Backend(t *testing.T, server *httptest.Server, env map[string]string) *S3Backend {
+	t.Setenv("S3_BUCKET", "bucket")
+	t.Setenv("S3_ENDPOINT", server.URL)
+	t.Setenv("S3_FORCE_PATH_STYLE", "true")
+	t.Setenv("S3_REGION", "")
+	t.Setenv("S3_PREFIX", "")
+	t.Setenv("S3_PUBLIC_URL", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	backend, err := NewS3BackendFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestS3BackendSaveURLDelete(t *testing.T) {
+	fake, server := newFakeS3(t)
+	backend := newTestS3Backend(t, server, nil)
+	data := []byte("\x89PNG\r\n\x1a\n fake image")
+
+	if err := backend.Save("2024/5/1/cat.png", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.objects["/bucket/2024/5/1/cat.png"]; !bytes.Equal(got, data) {
+		t.Fatalf("stored object = %q, want %q", got, data)
+	}
+	if got := fake.types["/bucket/2024/5/1/cat.png"]; got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	// 大小已知的小文件应该使用一次 PutObject，不能走分片上传
+	if n := fake.multipartRequests(); n != 0 {
+		t.Errorf("multipart uploads = %d, want 0", n)
+	}
+	// 配置了自定义地址时不应该请求 GetBucketLocation
+	for _, req := range fake.requests {
+		if strings.Contains(req, "location") {
+			t.Errorf("unexpected region lookup: %s", req)
+		}
+	}
+
+	if got, want := backend.URL("2024/5/1/cat.png"), server.URL+"/bucket/2024/5/1/cat.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+
+	exists, err := backend.Exists("2024/5/1/cat.png")
+	if err != nil || !exists {
+		t.Fatalf("Exists = %v, %v, want true", exists, err)
+	}
+	if err := backend.Delete("2024/5/1/cat.png"); err != nil {
+		t.Fatal(err)
+	}
+	exists, err = backend.Exists("2024/5/1/cat.png")
+	if err != nil || exists {
+		t.Fatalf("Exists after Delete = %v, %v, want false", exists, err)
+	}
+}
+
+func TestS3BackendUnknownSize(t *testing.T) {
+	fake, server := newFakeS3(t)
+	backend := newTestS3Backend(t, server, nil)
+	data := bytes.Repeat([]byte("x"), 1024)
+
+	// 大小未知时使用分片上传，分片大小为 s3PartSize
+	if err := backend.Save("2024/5/1/stream.png", io.MultiReader(bytes.NewReader(data)), -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.objects["/bucket/2024/5/1/stream.png"]; !bytes.Equal(got, data) {
+		t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestS3BackendPrefixAndPublicURL(t *testing.T) {
+	fake, server := newFakeS3(t)
+	backend := newTestS3Backend(t, server, map[string]string{
+		"S3_PREFIX":     "/images/",
+		"S3_PUBLIC_URL": "https://cdn.example.com/",
+	})
+	data := []byte("GIF89a")
+
+	if err := backend.Save("2024/5/1/a.gif", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/bucket/images/2024/5/1/a.gif"]; !ok {
+		t.Fatalf("object not stored under prefix, requests: %v", fake.requests)
+	}
+	if got, want := backend.URL("2024/5/1/a.gif"), "https://cdn.example.com/images/2024/5/1/a.gif"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+}
+
+func TestSaveImageChecksumMismatchLeavesNoObject(t *testing.T) {
+	useTestStorage(t)
+	fake, server := newFakeS3(t)
+	Storage = newTestS3Backend(t, server, nil)
+
+	// 不需要处理的 GIF 直接流式上传到对象存储
+	oldConvert := GIFConvert
+	GIFConvert = ""
+	t.Cleanup(func() { GIFConvert = oldConvert })
+	data := tinyGIF
+	wrong := []*expectedChecksum{{algorithm: "sha256", expected: strings.Repeat("0", 64), hash: sha256.New()}}
+	_, uploadErr := saveImage(ctx.Background(), "cat.gif", withChecksums(bytes.NewReader(data), wrong), int64(len(data)),
+		uploadOptions{clientIP: "127.0.0.1"})
+	if uploadErr == nil || uploadErr.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("saveImage error = %v, want status 422", uploadErr)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.objects) != 0 {
+		t.Errorf("objects left after a checksum mismatch: %v", fake.objects)
+	}
+}
+
+func TestKodoBackendURLStyle(t *testing.T) {
+	backend := &KodoBackend{S3Backend: &S3Backend{publicURL: "https://img.example.com"}, style: "-thumb"}
+	tests := map[string]string{
+		"2024/5/1/cat.png":  "https://img.example.com/2024/5/1/cat.png-thumb",
+		"2024/5/1/logo.svg": "https://img.example.com/2024/5/1/logo.svg",
+	}
+	for path, want := range tests {
+		if got := backend.URL(path); got != want {
+			t.Errorf("URL(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// errEmptySVG 清理后的 SVG 没有可以显示的内容
+var errEmptySVG = errors.New("empty svg")
+
+// svgBlockedElements 清理 SVG 时连同子元素一起删除的元素
+var svgBlockedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// sanitizeSVG 删除 SVG 中的脚本、事件处理属性、foreignObject 以及引用外部资源的链接和样式，
+// 注释、处理指令和 DOCTYPE 也会被删除。根元素不是 svg 或者清理后没有任何子元素时返回 errEmptySVG
+func sanitizeSVG(data []byte) ([]byte, error) {
+	// RawToken 不检查开始和结束标签是否匹配，先完整解析一遍确认是格式正确的 XML
+	validator := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := validator.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	depth, skipDepth, styleDepth, children := 0, 0, 0, 0
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if skipDepth > 0 {
+				continue
+			}
+			name := strings.ToLower(t.Name.Local)
+			if depth == 1 && name != "svg" {
+				return nil, errEmptySVG
+			}
+			if svgBlockedElements[name] || isHrefAnimation(t) {
+				skipDepth = depth
+				continue
+			}
+			if depth == 2 {
+				children++
+			}
+			if name == "style" {
+				styleDepth = depth
+			}
+			out.WriteString("<" + qualifiedName(t.Name))
+			for _, attr := range t.Attr {
+				if !isSafeSVGAttr(attr) {
+					continue
+				}
+				out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+				out.WriteString(svgEscaper.Replace(attr.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if skipDepth == 0 {
+				out.WriteString("</" + qualifiedName(t.Name) + ">")
+			}
+			if skipDepth == depth {
+				skipDepth = 0
+			}
+			if styleDepth == depth {
+				styleDepth = 0
+			}
+			depth--
+		case xml.CharData:
+			if skipDepth > 0 || depth == 0 || (styleDepth > 0 && hasExternalReference(string(t))) {
+				continue
+			}
+			out.WriteString(svgEscaper.Replace(string(t)))
+		}
+	}
+
+	if children == 0 {
+		return nil, errEmptySVG
+	}
+	return out.Bytes(), nil
+}
+
+// svgEscaper 转义文本和属性值，与 xml.EscapeText 不同，保留换行
+var svgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// qualifiedName RawToken 不解析命名空间，Space 中保存的是前缀
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// svgEmbeddedImages href 中允许内嵌的图片类型，SVG 本身不允许，避免绕过清理
+var svgEmbeddedImages = []string{"data:image/png;", "data:image/jpeg;", "data:image/gif;", "data:image/webp;"}
+
+// isSafeSVGAttr 删除 on 开头的事件处理属性、引用外部资源的链接（只允许 #id 和内嵌的位图）以及引用外部资源的样式
+func isSafeSVGAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	if strings.HasPrefix(name, "on") {
+		return false
+	}
+	if name == "href" || name == "src" {
+		value := strings.ToLower(strings.TrimSpace(attr.Value))
+		if strings.HasPrefix(value, "#") {
+			return true
+		}
+		for _, prefix := range svgEmbeddedImages {
+			if strings.HasPrefix(value, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return !hasExternalReference(attr.Value)
+}
+
+// isHrefAnimation animate、set 等元素可以在运行时修改链接，修改 href 的直接删除
+func isHrefAnimation(element xml.StartElement) bool {
+	for _, attr := range element.Attr {
+		if strings.ToLower(attr.Name.Local) == "attributename" && strings.Contains(strings.ToLower(attr.Value), "href") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExternalReference 样式或属性值中是否包含 @import 或者不是指向文档内部的 url()
+func hasExternalReference(value string) bool {
+	value = strings.ToLower(value)
+	if strings.Contains(value, "@import") {
+		return true
+	}
+	for {
+		i := strings.Index(value, "url(")
+		if i < 0 {
+			return false
+		}
+		value = strings.TrimLeft(value[i+len("url("):], " \t\r\n'\"")
+		if !strings.HasPrefix(value, "#") {
+			return true
+		}
+	}
+}
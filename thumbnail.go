@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+)
+
+// thumbnailPath 缩略图的保存路径，thumbs/<图片路径>.jpg
+func thumbnailPath(path string) string {
+	return "thumbs/" + path + ".jpg"
+}
+
+// thumbnailURL 返回图片 path 的缩略图地址，生成失败时返回原图地址。
+// existed 为 true 表示图片此前已经保存过，缩略图存在时直接复用
+func thumbnailURL(path string, existed bool, source func() (io.Reader, error)) string {
+	dst := thumbnailPath(path)
+	if existed {
+		if ok, err := Storage.Exists(dst); err == nil && ok {
+			return Storage.URL(dst)
+		}
+	}
+
+	r, err := source()
+	if err == nil {
+		err = createThumbnail(dst, r)
+	}
+	if err != nil {
+		slog.Warn("failed to create thumbnail", "path", path, "error", err)
+		return Storage.URL(path)
+	}
+	return Storage.URL(dst)
+}
+
+// createThumbnail 把 r 中的图片按比例缩小到最长边不超过 ThumbnailSize，保存为 JPEG 到 dst。
+// 比 ThumbnailSize 小的图片不放大，GIF 使用第一帧
+func createThumbnail(dst string, r io.Reader) error {
+	img, _, err := decodeImage(r)
+	if err != nil {
+		return err
+	}
+
+	thumb := imaging.Fit(img, ThumbnailSize, ThumbnailSize, imaging.Lanczos)
+
+	// JPEG 不支持透明，透明部分填充白色
+	canvas := imaging.New(thumb.Bounds().Dx(), thumb.Bounds().Dy(), color.White)
+	canvas = imaging.Overlay(canvas, thumb, image.Point{}, 1)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return err
+	}
+	return Storage.Save(dst, &buf, int64(buf.Len()))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"net/http"
+)
+
+// listener 需要启动的 HTTP 服务以及启动方式
+type listener struct {
+	server *http.Server
+	listen func() error
+}
+
+// listeners 根据 TLSMode 创建需要启动的服务。不开启 TLS 时只在 Port 上提供服务；
+// 开启后在 TLSPort 上提供 HTTPS 服务，Port 上的 HTTP 请求跳转到 HTTPS，acme 模式下同时处理 HTTP-01 验证
+func listeners(handler http.Handler) []*listener {
+	if TLSMode == TLSModeOff {
+		server := &http.Server{Addr: ":" + Port, Handler: handler}
+		return []*listener{{server, server.ListenAndServe}}
+	}
+
+	secure := &http.Server{Addr: ":" + TLSPort, Handler: handler}
+	plain := &http.Server{Addr: ":" + Port, Handler: http.HandlerFunc(redirectToHTTPS)}
+	if TLSMode == TLSModeManual {
+		return []*listener{
+			{secure, func() error { return secure.ListenAndServeTLS(TLSCertFile, TLSKeyFile) }},
+			{plain, plain.ListenAndServe},
+		}
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(TLSCacheDir),
+		HostPolicy: autocert.HostWhitelist(TLSDomain...),
+	}
+	secure.TLSConfig = &tls.Config{
+		GetCertificate: manager.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", "acme-tls/1"},
+	}
+	plain.Handler = manager.HTTPHandler(plain.Handler)
+	return []*listener{
+		{secure, func() error { return secure.ListenAndServeTLS("", "") }},
+		{plain, plain.ListenAndServe},
+	}
+}
+
+// redirectToHTTPS 把 HTTP 请求永久跳转到 TLSPort 上的同一地址
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if TLSPort != "443" {
+		host = net.JoinHostPort(host, TLSPort)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
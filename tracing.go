@@ -0,0 +1,92 @@
+package main
+
+import (
+	ctx "context"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName 追踪数据中的 service.name
+const serviceName = "go-drawing-bed"
+
+// tracer 创建上传和存储相关的 span，没有调用 setupTracing 时使用 OpenTelemetry 默认的空实现，没有额外开销
+var tracer = otel.Tracer(serviceName)
+
+// tracerProvider 开启追踪时导出追踪数据的 TracerProvider，没有开启时为 nil
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing 把追踪数据通过 OTLP/HTTP 导出到 OTEL_EXPORTER_OTLP_ENDPOINT。
+// 导出器的其他配置（请求头、超时等）使用 OpenTelemetry 标准的 OTEL_EXPORTER_OTLP_* 环境变量
+func setupTracing() error {
+	exporter, err := otlptracehttp.New(ctx.Background())
+	if err != nil {
+		return err
+	}
+	res, err := resource.New(ctx.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return nil
+}
+
+// shutdownTracing 退出前发送还没有导出的追踪数据，没有开启追踪时不做任何操作
+func shutdownTracing(c ctx.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(c)
+}
+
+// tracingMiddleware 为每个请求创建根 span，请求头中带有 traceparent 时作为其子 span。
+// 之后通过 context.Request.Context() 创建的 span 都在这个 span 之下
+func tracingMiddleware(context *gin.Context) {
+	parent := otel.GetTextMapPropagator().Extract(context.Request.Context(), propagation.HeaderCarrier(context.Request.Header))
+	route := context.FullPath()
+	if route == "" {
+		route = "unknown route"
+	}
+	spanCtx, span := tracer.Start(parent, context.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(context.Request.Method),
+			semconv.HTTPRoute(route),
+			semconv.ClientAddress(context.ClientIP()),
+			attribute.String("request_id", context.GetString(requestIDKey)),
+		),
+	)
+	defer span.End()
+	context.Request = context.Request.WithContext(spanCtx)
+
+	context.Next()
+
+	status := context.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, context.Errors.String())
+	}
+}
+
+// endSpan 结束 span，err 不为 nil 时记录到 span 中
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
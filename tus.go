@@ -0,0 +1,345 @@
+package main
+
+import (
+	ctx "context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TusVersion 支持的 tus 协议版本
+const TusVersion = "1.0.0"
+
+// tusLocks 每个上传任务一把锁，避免同一个任务的 PATCH 并发写入
+var tusLocks sync.Map
+
+// tusInfo 上传任务的元数据，保存在 TusDir/<id>.info
+type tusInfo struct {
+	ID       string    `json:"id"`
+	Length   int64     `json:"length"`
+	Filename string    `json:"filename"`
+	Created  time.Time `json:"created"`
+	// KeepEXIF 通过 Upload-Metadata 中的 keep_exif 要求保留元数据
+	KeepEXIF bool `json:"keep_exif,omitempty"`
+	// NoWatermark 通过 Upload-Metadata 中的 nowatermark 要求不添加水印
+	NoWatermark bool `json:"nowatermark,omitempty"`
+	// UserID 创建上传任务的登录用户，保存图片时记录为上传者
+	UserID *int64 `json:"user_id,omitempty"`
+	// Result 上传完成后的响应数据
+	Result gin.H `json:"result,omitempty"`
+}
+
+// tusMiddleware 检查客户端的协议版本并在响应中带上 Tus-Resumable，OPTIONS 和获取结果的 GET 请求不检查
+func tusMiddleware(context *gin.Context) {
+	context.Header("Tus-Resumable", TusVersion)
+	method := context.Request.Method
+	if method != http.MethodOptions && method != http.MethodGet && context.GetHeader("Tus-Resumable") != TusVersion {
+		context.Header("Tus-Version", TusVersion)
+		context.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	context.Next()
+}
+
+// tusOptionsHandler 返回服务端支持的协议信息
+func tusOptionsHandler(context *gin.Context) {
+	context.Header("Tus-Version", TusVersion)
+	context.Header("Tus-Extension", "creation,termination")
+	context.Header("Tus-Max-Size", strconv.FormatInt(maxUploadSize(), 10))
+	context.Status(http.StatusNoContent)
+}
+
+// tusCreateHandler 创建上传任务
+func tusCreateHandler(context *gin.Context) {
+	length, err := strconv.ParseInt(context.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length 无效！"})
+		return
+	}
+	if length > maxUploadSize() {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fileTooLargeMessage()})
+		return
+	}
+
+	id, err := newTusID()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	metadata := parseTusMetadata(context.GetHeader("Upload-Metadata"))
+	info := &tusInfo{
+		ID:       id,
+		Length:   length,
+		Filename: metadata["filename"],
+		KeepEXIF: metadata["keep_exif"] == "1" || metadata["keep_exif"] == "true",
+		// 只有提供了 API_KEY 的请求才能跳过水印
+		NoWatermark: (metadata["nowatermark"] == "1" || metadata["nowatermark"] == "true") && trustedUploader(context),
+		Created:     time.Now(),
+		UserID:      requestUserID(context),
+	}
+	if info.Filename == "" {
+		info.Filename = id
+	}
+
+	err = os.MkdirAll(TusDir, 0750)
+	if err == nil {
+		err = writeTusInfo(info)
+	}
+	if err == nil {
+		err = os.WriteFile(tusDataPath(id), nil, 0640)
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	context.Header("Location", "/files/"+id)
+	context.Status(http.StatusCreated)
+}
+
+// tusHeadHandler 查询已经上传的字节数
+func tusHeadHandler(context *gin.Context) {
+	info, offset, err := readTusUpload(context.Param("id"))
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+	context.Header("Cache-Control", "no-store")
+	context.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	context.Header("Upload-Length", strconv.FormatInt(info.Length, 10))
+	context.Status(http.StatusOK)
+}
+
+// tusPatchHandler 从 Upload-Offset 处追加数据，全部上传完成后按普通上传的流程保存图片
+func tusPatchHandler(context *gin.Context) {
+	id := context.Param("id")
+
+	if context.ContentType() != "application/offset+octet-stream" {
+		context.Status(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	info, offset, err := readTusUpload(id)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	requestOffset, err := strconv.ParseInt(context.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || requestOffset != offset {
+		context.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		context.Status(http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(tusDataPath(id), os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// 最多写到 Upload-Length，多出来的数据丢弃
+	n, copyErr := io.Copy(file, io.LimitReader(context.Request.Body, info.Length-offset))
+	err = file.Close()
+	offset += n
+	if copyErr != nil || err != nil {
+		// 连接中断时保留已经写入的数据，客户端可以从新的 offset 继续上传
+		context.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	if offset < info.Length {
+		context.Status(http.StatusNoContent)
+		return
+	}
+
+	result, uploadErr := finishTusUpload(context, info, context.ClientIP())
+	if uploadErr != nil {
+		removeTusUpload(id)
+		respondUploadError(context, uploadErr)
+		return
+	}
+	info.Result = result
+	err = writeTusInfo(info)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.Status(http.StatusNoContent)
+}
+
+// tusDeleteHandler 终止上传任务并删除已经上传的数据，已经保存的图片不会被删除
+func tusDeleteHandler(context *gin.Context) {
+	id := context.Param("id")
+
+	lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, _, err := readTusUpload(id); err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+	removeTusUpload(id)
+	context.Status(http.StatusNoContent)
+}
+
+// tusResultHandler 上传完成后获取图片地址
+func tusResultHandler(context *gin.Context) {
+	info, _, err := readTusUpload(context.Param("id"))
+	if err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "上传任务不存在！"})
+		return
+	}
+	if info.Result == nil {
+		context.JSON(http.StatusConflict, gin.H{"error": "图片尚未上传完成！"})
+		return
+	}
+	context.JSON(http.StatusOK,
+		gin.H{
+			"message": "图片上传成功！",
+			"data":    info.Result,
+		},
+	)
+}
+
+// finishTusUpload 校验并保存已经上传完成的文件，clientIP 为上传者的 IP
+func finishTusUpload(c ctx.Context, info *tusInfo, clientIP string) (gin.H, *uploadError) {
+	file, err := os.Open(tusDataPath(info.ID))
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}(file)
+
+	opts := uploadOptions{clientIP: clientIP, userID: info.UserID, keepEXIF: info.KeepEXIF, watermark: !info.NoWatermark}
+	return saveImage(c, info.Filename, file, info.Length, opts)
+}
+
+// cleanupTusUploads 定期删除超过 TusTTL 没有更新的上传任务
+func cleanupTusUploads() {
+	for range time.Tick(time.Hour) {
+		entries, err := os.ReadDir(TusDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			id, found := strings.CutSuffix(entry.Name(), ".info")
+			if !found {
+				continue
+			}
+			stat, err := entry.Info()
+			if err != nil || time.Since(stat.ModTime()) < TusTTL {
+				continue
+			}
+			if data, err := os.Stat(tusDataPath(id)); err == nil && time.Since(data.ModTime()) < TusTTL {
+				continue
+			}
+			slog.Info("removing expired tus upload", "id", id)
+			removeTusUpload(id)
+		}
+	}
+}
+
+// readTusUpload 读取上传任务的元数据和当前已上传的字节数
+func readTusUpload(id string) (*tusInfo, int64, error) {
+	if !isTusID(id) {
+		return nil, 0, fs.ErrNotExist
+	}
+	data, err := os.ReadFile(tusInfoPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	info := &tusInfo{}
+	err = json.Unmarshal(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.Result != nil {
+		// 已经完成的任务数据文件已被删除
+		return info, info.Length, nil
+	}
+	stat, err := os.Stat(tusDataPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	return info, stat.Size(), nil
+}
+
+func writeTusInfo(info *tusInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tusInfoPath(info.ID), data, 0640)
+}
+
+func removeTusUpload(id string) {
+	_ = os.Remove(tusInfoPath(id))
+	_ = os.Remove(tusDataPath(id))
+	tusLocks.Delete(id)
+}
+
+func tusInfoPath(id string) string {
+	return filepath.Join(TusDir, id+".info")
+}
+
+func tusDataPath(id string) string {
+	return filepath.Join(TusDir, id+".bin")
+}
+
+// newTusID 生成 32 位十六进制的任务 ID
+func newTusID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isTusID 检查任务 ID 格式，避免拼接出任意路径
+func isTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// parseTusMetadata 解析 Upload-Metadata，格式为逗号分隔的 "key base64(value)"
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
@@ -0,0 +1,854 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"image"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadFields 上传文件可以使用的表单字段，file 可以重复出现
+var uploadFields = []string{"file", "file[]", "files", "files[]"}
+
+// 保存文件时的命名方式
+const (
+	// NamingOriginal 使用上传时的原始文件名
+	NamingOriginal = "original"
+	// NamingHash 使用 SHA-256 的前 16 位加上根据文件类型判断的扩展名
+	NamingHash = "hash"
+	// NamingUUID 使用随机的 UUIDv4 加上根据文件类型判断的扩展名
+	NamingUUID = "uuid"
+)
+
+// uploadError 带 HTTP 状态码的上传错误
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// respondUploadError 返回上传错误，并把错误记录到请求日志中。响应中带有 request_id，方便用户反馈问题时定位日志
+func respondUploadError(context *gin.Context, err *uploadError) {
+	_ = context.Error(err)
+	context.JSON(err.Status, gin.H{"error": err.Message, "request_id": context.GetString(requestIDKey)})
+}
+
+func uploadHandler(context *gin.Context) {
+
+	form, err := context.MultipartForm()
+	if err != nil {
+		respondUploadError(context, &uploadError{http.StatusInternalServerError, err.Error()})
+		return
+	}
+
+	var uploads []*multipart.FileHeader
+	for _, field := range uploadFields {
+		uploads = append(uploads, form.File[field]...)
+	}
+
+	if len(uploads) == 0 {
+		respondUploadError(context, &uploadError{http.StatusBadRequest, "请选择要上传的图片！"})
+		return
+	}
+
+	// 只上传了一张图片时可以通过 filename 字段指定保存的文件名
+	customName := ""
+	if len(uploads) == 1 {
+		customName = context.PostForm("filename")
+	}
+	generate := generateNames(context)
+	opts := requestUploadOptions(context)
+
+	// 通过 album_id 字段把上传的图片加入相册
+	var albumID int64
+	if v := context.PostForm("album_id"); v != "" {
+		var err error
+		albumID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondUploadError(context, &uploadError{http.StatusBadRequest, errAlbumNotFound.Error()})
+			return
+		}
+		_, err = findAlbum(albumID)
+		if errors.Is(err, errAlbumNotFound) {
+			respondUploadError(context, &uploadError{http.StatusBadRequest, err.Error()})
+			return
+		}
+		if err != nil {
+			respondUploadError(context, &uploadError{http.StatusInternalServerError, err.Error()})
+			return
+		}
+	}
+
+	// 通过 expires 字段或参数设置图片的有效期，过期后自动删除
+	var expires time.Time
+	v := context.PostForm("expires")
+	if v == "" {
+		v = context.Query("expires")
+	}
+	if v != "" {
+		var err error
+		expires, err = parseExpires(v, time.Now())
+		if err != nil {
+			respondUploadError(context, &uploadError{http.StatusBadRequest, err.Error()})
+			return
+		}
+	}
+
+	// 只上传了一张图片时可以通过 X-Content-SHA256、X-Content-MD5 校验上传的内容
+	var checksums []*expectedChecksum
+	if len(uploads) == 1 {
+		var uploadErr *uploadError
+		checksums, uploadErr = requestChecksums(context)
+		if uploadErr != nil {
+			respondUploadError(context, uploadErr)
+			return
+		}
+	}
+
+	// 只上传了一个 file 时保持原来的响应格式
+	if len(uploads) == 1 && len(form.File["file"]) == 1 {
+		data, uploadErr := saveUpload(context, uploads[0], uploadName(uploads[0], customName, generate), checksums, opts)
+		if uploadErr != nil {
+			respondUploadError(context, uploadErr)
+			return
+		}
+		addToAlbum(context, albumID, data)
+		applyExpiry(context, expires, data)
+		context.JSON(http.StatusOK,
+			gin.H{
+				"message": "图片上传成功！",
+				"data":    data,
+			},
+		)
+		return
+	}
+
+	// 多个文件逐个处理，单个文件失败不影响其它文件
+	results := make([]gin.H, 0, len(uploads))
+	succeeded := 0
+	for _, upload := range uploads {
+		data, uploadErr := saveUpload(context, upload, uploadName(upload, customName, generate), checksums, opts)
+		if uploadErr != nil {
+			_ = context.Error(uploadErr)
+			results = append(results, gin.H{"name": upload.Filename, "error": uploadErr.Message})
+			continue
+		}
+		succeeded++
+		addToAlbum(context, albumID, data)
+		applyExpiry(context, expires, data)
+		results = append(results, data)
+	}
+
+	response := gin.H{
+		"message": fmt.Sprintf("共 %d 张图片，上传成功 %d 张！", len(uploads), succeeded),
+		"data":    results,
+	}
+	status := http.StatusOK
+	if succeeded == 0 {
+		status = http.StatusBadRequest
+		response["request_id"] = context.GetString(requestIDKey)
+	}
+	context.JSON(status, response)
+}
+
+// addToAlbum 把刚上传的图片加入相册，albumID 为 0 时不做任何操作。图片已经保存成功，加入相册失败时只记录日志
+func addToAlbum(context *gin.Context, albumID int64, data gin.H) {
+	if albumID == 0 {
+		return
+	}
+	path, _ := data["path"].(string)
+	if err := addAlbumImage(albumID, path); err != nil {
+		slog.ErrorContext(context, "failed to add image to album", "album_id", albumID, "path", path, "error", err)
+	}
+}
+
+// applyExpiry 为刚上传的图片设置过期时间并在 data 中返回，expires 为零值时不做任何操作。
+// 与已有图片内容相同时保存路径是共用的，不设置过期时间
+func applyExpiry(context *gin.Context, expires time.Time, data gin.H) {
+	if expires.IsZero() || data["duplicate"] == true || data["already_existed"] == true {
+		return
+	}
+	path, _ := data["path"].(string)
+	if err := setExpiry(path, expires); err != nil {
+		slog.ErrorContext(context, "failed to set image expiry", "path", path, "error", err)
+		return
+	}
+	data["expires_at"] = expires
+}
+
+// saveUpload 保存单个上传的文件，返回响应中的 data。
+// name 为保存时使用的文件名，为空时自动生成，checksums 为客户端提供的校验和
+func saveUpload(c ctx.Context, upload *multipart.FileHeader, name string, checksums []*expectedChecksum, opts uploadOptions) (gin.H, *uploadError) {
+	file, err := upload.Open()
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+	}
+
+	defer func(file multipart.File) {
+		err := file.Close()
+		if err != nil {
+			slog.Error("failed to close uploaded file", "error", err)
+		}
+	}(file)
+
+	return saveImage(c, name, withChecksums(file, checksums), upload.Size, opts)
+}
+
+// saveImage 校验 r 中的图片并保存到存储后端，size 为文件大小，opts 为本次上传的选项，返回响应中的 data。
+// c 为请求的 context，用于追踪和日志中的请求 ID。
+// fileName 为空、不安全或者是 image.png、blob 这类通用名称时，按时间生成文件名
+func saveImage(c ctx.Context, fileName string, r io.Reader, size int64, opts uploadOptions) (gin.H, *uploadError) {
+	if size > maxUploadSize() {
+		return nil, &uploadError{http.StatusBadRequest, fileTooLargeMessage()}
+	}
+
+	_, span := tracer.Start(c, "upload.read_head")
+	head, err := readHead(r, size)
+	if err != nil {
+		endSpan(span, err)
+		return nil, readError(err, "")
+	}
+	endSpan(span, nil)
+
+	_, span = tracer.Start(c, "upload.detect_type")
+	kind, ok := allowedType(head)
+	span.SetAttributes(attribute.String("upload.mime_type", kind.MIME.Value), attribute.Bool("upload.allowed", ok))
+	span.End()
+	if !ok {
+		return nil, &uploadError{http.StatusBadRequest, notAllowedTypeMessage()}
+	}
+	if AllowedTypes != nil && !AllowedTypes[kind.Extension] {
+		return nil, &uploadError{http.StatusUnsupportedMediaType, fmt.Sprintf("不支持的图片类型：%s！", kind.Extension)}
+	}
+	if size > sizeLimit(kind.Extension) {
+		return nil, &uploadError{http.StatusBadRequest, typeTooLargeMessage(kind.Extension)}
+	}
+
+	// 文件名来自客户端，去掉目录部分和不安全的字符，无法使用时重新生成
+	fileName = sanitizeFilename(fileName)
+	if fileName == "" || isGenericFilename(fileName) {
+		fileName, err = generatedFilename(kind.Extension)
+		if err != nil {
+			return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+		}
+	}
+	originalName := fileName
+
+	// 上面已经读取了文件头，需要把它拼回去，同时统计上传的原始大小
+	input := &countingReader{r: r, n: int64(len(head)), limit: sizeLimit(kind.Extension)}
+	body := io.MultiReader(bytes.NewReader(head), input)
+
+	// 宽高超过限制的图片不保存
+	if needsDimensionCheck() && isImageType(kind) {
+		var uploadErr *uploadError
+		body, uploadErr = checkDimensions(body)
+		if uploadErr != nil {
+			return nil, uploadErr
+		}
+	}
+
+	// 大多数浏览器不能显示 HEIC/HEIF，转换为 JPEG 后保存，开启 KEEP_HEIC_ORIGINAL 时同时保存原图
+	var data, original []byte
+	originalExt := ""
+	// originalDst 同时保存的原图的实际路径，记录在上传记录中，删除图片时一起删除
+	originalDst := ""
+	ext := kind.Extension
+	if ext == "heif" {
+		original, err = io.ReadAll(body)
+		if err != nil {
+			return nil, readError(err, ext)
+		}
+		data, err = convertHEIC(original)
+		if errors.Is(err, errTooManyPixels) {
+			return nil, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		if err != nil {
+			return nil, &uploadError{http.StatusUnsupportedMediaType, "不支持该 HEIC/HEIF 图片，请转换为 JPEG 后再上传！"}
+		}
+		ext = "jpg"
+		fileName = strings.TrimSuffix(fileName, path.Ext(fileName)) + ".jpg"
+		body = bytes.NewReader(data)
+		originalExt = "heic"
+		if !KeepHEICOriginal {
+			original = nil
+		}
+	}
+
+	// 动图 GIF 通常很大，配置了 GIF_CONVERT 时转换为动图 WebP 或 MP4，超时、失败或者没有变小时保存原图，
+	// 开启 KEEP_GIF_ORIGINAL 时同时保存原图。转换后的动图不再添加水印，缩略图、宽高等信息从 GIF 读取
+	var animatedGIF []byte
+	if ext == "gif" && GIFConvert != "" {
+		data, err = io.ReadAll(body)
+		if err != nil {
+			return nil, readError(err, ext)
+		}
+		body = bytes.NewReader(data)
+		if gifFrames(data) > 1 {
+			_, span := tracer.Start(c, "upload.convert_gif")
+			converted, newExt, err := convertAnimatedGIF(c, data)
+			endSpan(span, err)
+			switch {
+			case err != nil:
+				slog.WarnContext(c, "failed to convert animated gif, keeping the original", "name", fileName, "error", err)
+			case len(converted) >= len(data):
+				// 很小的动图转换后可能反而变大
+			default:
+				animatedGIF = data
+				if KeepGIFOriginal {
+					original, originalExt = data, "gif"
+				}
+				data, ext = converted, newExt
+				fileName = strings.TrimSuffix(fileName, path.Ext(fileName)) + "." + ext
+				body = bytes.NewReader(data)
+				opts.watermark = false
+			}
+		}
+	}
+
+	// SVG 中可以包含脚本，只保存清理后的内容
+	if kind == svgType {
+		data, err = io.ReadAll(body)
+		if err != nil {
+			return nil, readError(err, ext)
+		}
+		data, err = sanitizeSVG(data)
+		if err != nil {
+			return nil, &uploadError{http.StatusBadRequest, "SVG 无效或者清理后没有可以显示的内容！"}
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// 开启异步处理时先占用队列中的位置，队列已满时不保存
+	enqueued := false
+	if AsyncProcessing {
+		if !jobQueue.reserve() {
+			return nil, &uploadError{http.StatusTooManyRequests, "处理队列已满，请稍后再试！"}
+		}
+		defer func() {
+			if !enqueued {
+				jobQueue.release()
+			}
+		}()
+	}
+
+	// 按 EXIF 中的 Orientation 旋转 JPEG，需要在缩放、删除 EXIF 之前进行
+	if AutoOrient && ext == "jpg" {
+		data, err = io.ReadAll(body)
+		if err != nil {
+			return nil, readError(err, kind.Extension)
+		}
+		var transform string
+		data, transform, err = autoOrient(data)
+		if errors.Is(err, errTooManyPixels) {
+			return nil, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		if err != nil {
+			return nil, &uploadError{http.StatusBadRequest, "图片解码失败！"}
+		}
+		if transform != "" {
+			slog.InfoContext(c, "auto-oriented image", "name", fileName, "transform", transform)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// 图片超过最大宽高时保存缩小后的版本，配置了 WATERMARK_TEXT 时添加水印，
+	// 开启 CONVERT_TO_WEBP 时把 JPEG、PNG 转换为 WebP，
+	// 配置了 JPEG_QUALITY 时较大的 JPEG 重新压缩为渐进式 JPEG。开启异步处理时这些操作在保存原图之后进行
+	if needsTransform(ext, opts.watermark) && !AsyncProcessing {
+		data, err = io.ReadAll(body)
+		if err != nil {
+			return nil, readError(err, kind.Extension)
+		}
+		var newExt string
+		_, span := tracer.Start(c, "upload.transform")
+		data, newExt, err = transformImage(data, ext, opts.watermark)
+		endSpan(span, err)
+		if errors.Is(err, errTooManyPixels) {
+			return nil, &uploadError{http.StatusBadRequest, err.Error()}
+		}
+		if err != nil {
+			return nil, &uploadError{http.StatusBadRequest, "图片解码失败！"}
+		}
+		if newExt != ext {
+			fileName = strings.TrimSuffix(fileName, path.Ext(fileName)) + "." + newExt
+			ext = newExt
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// 按 EXIF_MODE 删除 JPEG、PNG、WebP 中的 EXIF、XMP 等元数据，或者只删除 GPS 位置信息，避免泄露拍摄位置，
+	// 都不重新编码。请求中带有 keep_exif=1 时保留
+	strippedEXIF := false
+	exifMode := EXIFMode
+	if opts.keepEXIF {
+		exifMode = EXIFModeKeep
+	}
+	if exifMode != EXIFModeKeep && (ext == "jpg" || ext == "png" || ext == "webp") {
+		if data == nil {
+			data, err = io.ReadAll(body)
+			if err != nil {
+				return nil, readError(err, kind.Extension)
+			}
+		}
+		if exifMode == EXIFModeStripGPS {
+			data, strippedEXIF = stripGPS(data, ext)
+		} else {
+			data, strippedEXIF = stripMetadata(data, ext)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// 异步处理时需要保留原图内容
+	if AsyncProcessing && data == nil {
+		data, err = io.ReadAll(body)
+		if err != nil {
+			return nil, readError(err, kind.Extension)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// 按内容去重或命名时需要先读取完整内容计算 SHA-256
+	var sum string
+	if ContentAddressed || Deduplicate || FilenameTemplate.UsesHash() {
+		if data == nil {
+			data, err = io.ReadAll(body)
+			if err != nil {
+				return nil, readError(err, kind.Extension)
+			}
+		}
+		sum = sha256Hex(data)
+
+		// 相同内容的上传串行处理，避免并发上传同一个新文件时保存两份
+		unlock := hashLocks.Lock(sum)
+		defer unlock()
+
+		body = bytes.NewReader(data)
+	}
+
+	var dst string
+	existed := false
+	stored := int64(len(data))
+
+	// 保存时顺便计算保存内容的校验和，不需要再次读取文件
+	sha256Hash, md5Hash := sha256.New(), md5.New()
+	checksum := io.MultiWriter(sha256Hash, md5Hash)
+	switch {
+	case ContentAddressed:
+		dst = "blobs/" + sum
+		existed, err = Storage.Exists(dst)
+	case Deduplicate:
+		dst, existed, err = lookupDuplicate(sum)
+	}
+	if err != nil {
+		return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+	}
+
+	// 生成缩略图和读取宽高时需要再次读取文件内容，不能 Seek 的来源在保存时顺便缓存一份
+	var buf bytes.Buffer
+	source := func() (io.Reader, error) {
+		if data != nil {
+			return bytes.NewReader(data), nil
+		}
+		if seeker, ok := r.(io.ReadSeeker); ok {
+			_, err := seeker.Seek(0, io.SeekStart)
+			return seeker, err
+		}
+		return bytes.NewReader(buf.Bytes()), nil
+	}
+	// 转换后的动图从原来的 GIF 读取宽高、生成缩略图
+	imageSource := source
+	if animatedGIF != nil {
+		imageSource = func() (io.Reader, error) {
+			return bytes.NewReader(animatedGIF), nil
+		}
+	}
+
+	if existed {
+		_, _ = checksum.Write(data)
+	} else {
+		if !ContentAddressed {
+			var unlock func()
+			dst, fileName, unlock, err = renderFilename(fileName, ext, sum)
+			if errors.Is(err, errInvalidFilename) {
+				return nil, &uploadError{http.StatusBadRequest, err.Error()}
+			}
+			if err != nil {
+				return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+			}
+			defer unlock()
+		}
+
+		if _, ok := r.(io.Seeker); !ok && data == nil {
+			body = io.TeeReader(body, &buf)
+		}
+
+		// 处理过的图片已经读入 data，否则 body 是完整的原始内容，大小为请求中的 size
+		objectSize := size
+		if data != nil {
+			objectSize = int64(len(data))
+		} else if objectSize <= 0 {
+			objectSize = -1
+		}
+		counter := &countingReader{r: io.TeeReader(body, checksum)}
+		_, span := tracer.Start(c, "storage.save", trace.WithAttributes(attribute.String("storage.path", dst)))
+		err = Storage.Save(dst, counter, objectSize)
+		span.SetAttributes(attribute.Int64("storage.bytes", counter.n))
+		endSpan(span, err)
+		var checksumErr *checksumError
+		if errors.As(err, &checksumErr) {
+			// 已知大小时对象存储会在读到结尾之前发送完整个对象，校验失败时它已经保存，需要删除
+			if err := Storage.Delete(dst); err != nil {
+				slog.ErrorContext(c, "failed to delete upload with mismatched checksum", "path", dst, "error", err)
+			}
+		}
+		if err != nil {
+			return nil, readError(err, kind.Extension)
+		}
+		// 同名文件被替换时之前缓存的缩放版本不再有效
+		purgeVariants(dst)
+
+		stored = counter.n
+		uploadBytesTotal.Add(float64(stored))
+
+		if original != nil {
+			// 同名的原图路径可能已经被其它上传使用，不能直接覆盖
+			var unlock func()
+			originalDst, unlock, err = reservePath(originalPath(dst, originalExt))
+			if err != nil {
+				return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+			}
+			err = Storage.Save(originalDst, bytes.NewReader(original), int64(len(original)))
+			unlock()
+			if err != nil {
+				return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+			}
+		}
+	}
+
+	width, height, err := imageDimensions(imageSource)
+	if err != nil {
+		slog.DebugContext(c, "failed to read image dimensions", "path", dst, "error", err)
+	}
+	sha256Sum := hex.EncodeToString(sha256Hash.Sum(nil))
+	// 占位图、主色调和感知哈希只用于展示和查找相似图片，无法解码时不影响上传
+	var blurHash, color string
+	var pHash *uint64
+	if isImageType(kind) {
+		if preview := decodePreview(imageSource); preview != nil {
+			blurHash = imageBlurHash(preview)
+			if DominantColor {
+				color = dominantColor(preview)
+			}
+			if hash, ok := perceptualHash(preview); ok {
+				pHash = &hash
+			}
+		}
+	}
+	var similar []similarImage
+	if pHash != nil {
+		// 需要在写入本次的上传记录之前查询
+		similar, err = findSimilarImages(*pHash, dst)
+		if err != nil {
+			slog.ErrorContext(c, "failed to find similar images", "path", dst, "error", err)
+		}
+	}
+
+	if !existed {
+		// 上传记录写入失败只影响文件列表、统计和之后的去重，不影响本次上传
+		_, span := tracer.Start(c, "db.insert_image")
+		err = insertImage(&imageRecord{
+			SHA256:           sha256Sum,
+			OriginalFilename: originalName,
+			StoredPath:       dst,
+			MimeType:         mimeType(ext),
+			SizeBytes:        stored,
+			Width:            width,
+			Height:           height,
+			UploadedAt:       time.Now(),
+			UploaderIP:       opts.clientIP,
+			BlurHash:         blurHash,
+			DominantColor:    color,
+			PHash:            pHash,
+			UploadedBy:       opts.userID,
+			OriginalPath:     originalDst,
+		})
+		endSpan(span, err)
+		if err != nil {
+			slog.ErrorContext(c, "failed to record image", "path", dst, "error", err)
+		}
+		if err := recordUpload(dst); err != nil {
+			slog.ErrorContext(c, "failed to record upload", "path", dst, "error", err)
+		}
+	}
+
+	// 异步处理时缩略图生成之前先返回原图地址
+	var job *processingJob
+	thumbnail := Storage.URL(dst)
+	if AsyncProcessing && !existed && isImageType(kind) && animatedGIF == nil {
+		job, err = jobQueue.enqueue(dst, ext, data, opts.watermark)
+		if err != nil {
+			return nil, &uploadError{http.StatusInternalServerError, err.Error()}
+		}
+		enqueued = true
+	} else if isImageType(kind) {
+		_, span := tracer.Start(c, "upload.thumbnail")
+		thumbnail = thumbnailURL(dst, existed, imageSource)
+		span.End()
+	}
+
+	result := gin.H{
+		"name":          fileName,
+		"path":          dst,
+		"url":           Storage.URL(dst),
+		"thumbnail_url": thumbnail,
+		"size":          stored,
+		"original_size": input.n,
+		"saved_size":    stored,
+		"sha256":        sha256Sum,
+		"md5":           hex.EncodeToString(md5Hash.Sum(nil)),
+		"width":         pixels(width),
+		"height":        pixels(height),
+	}
+	if blurHash != "" {
+		result["blurhash"] = blurHash
+	}
+	if DominantColor {
+		result["dominant_color"] = nil
+		if color != "" {
+			result["dominant_color"] = color
+		}
+	}
+	if similar != nil {
+		result["similar_to"] = similar
+	}
+	if ResponseLinks {
+		result["links"] = imageLinks(fileName, Storage.URL(dst))
+	}
+	result["exif_mode"] = exifMode
+	if exifMode != EXIFModeKeep {
+		result["stripped_exif"] = strippedEXIF
+	}
+	if ContentAddressed {
+		result["already_existed"] = existed
+	}
+	if Deduplicate {
+		result["duplicate"] = existed
+	}
+	if originalDst != "" {
+		result["original_url"] = Storage.URL(originalDst)
+	}
+	if job != nil {
+		result["job_id"] = job.ID
+		result["status"] = JobPending
+	} else {
+		// 异步处理时格式要等任务完成后才能确定
+		result["format"] = ext
+	}
+
+	notifyUpload(fileName, Storage.URL(dst), stored, mimeType(ext))
+	return result, nil
+}
+
+// readHead 读取判断文件类型需要的文件头，size 为文件大小，未知时小于等于 0。
+// filetype 最多需要 261 字节的文件头，一次 Read 不一定能读满，需要使用 io.ReadFull
+func readHead(r io.Reader, size int64) ([]byte, error) {
+	headSize := int64(261)
+	if size > 0 {
+		headSize = min(headSize, size)
+	}
+	head := make([]byte, headSize)
+	n, err := io.ReadFull(r, head)
+	// 文件比 261 字节小时会返回 io.ErrUnexpectedEOF 或者 io.EOF
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// originalPath 转换格式后同时保存的原图的默认路径，与转换后的文件放在一起，扩展名为 ext。
+// 该路径已经存在时 reservePath 会加上随机后缀，实际路径以上传记录中的 OriginalPath 为准
+func originalPath(name string, ext string) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + "." + ext
+}
+
+// imageDimensions 只读取图片头部获取宽高，无法识别的格式返回 nil 和读取时的错误
+func imageDimensions(source func() (io.Reader, error)) (*int, *int, error) {
+	r, err := source()
+	if err != nil {
+		return nil, nil, err
+	}
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &config.Width, &config.Height, nil
+}
+
+// pixels 返回 imageDimensions 读取到的宽或高，无法识别时上传接口返回 0
+func pixels(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// readError 把读取 ext 类型的图片内容时的错误转换为 uploadError，超过大小上限时返回 413，
+// 对象存储返回错误时按错误码返回 413、503、507 或 502，错误信息中带有错误码
+func readError(err error, ext string) *uploadError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &uploadError{http.StatusRequestEntityTooLarge, fileTooLargeMessage()}
+	}
+	if errors.Is(err, errTooLarge) {
+		return &uploadError{http.StatusRequestEntityTooLarge, typeTooLargeMessage(ext)}
+	}
+	var checksumErr *checksumError
+	if errors.As(err, &checksumErr) {
+		return &uploadError{http.StatusUnprocessableEntity, checksumErr.Error()}
+	}
+	var storageErr *storageError
+	if errors.As(err, &storageErr) {
+		return &uploadError{storageErr.Status(), "保存到对象存储失败：" + storageErr.Error()}
+	}
+	return &uploadError{http.StatusInternalServerError, err.Error()}
+}
+
+// fileTooLargeMessage 文件超过所有类型中最大的大小上限时的错误提示
+func fileTooLargeMessage() string {
+	return fmt.Sprintf("请将图片大小压缩至不超过%s！", formatSize(maxUploadSize()))
+}
+
+// typeTooLargeMessage ext 类型的图片超过其大小上限时的错误提示
+func typeTooLargeMessage(ext string) string {
+	return fmt.Sprintf("%s 图片大小不能超过%s！", ext, formatSize(sizeLimit(ext)))
+}
+
+// sizeLimit 返回 ext 类型图片的大小上限
+func sizeLimit(ext string) int64 {
+	if limit, ok := SizeLimits[ext]; ok {
+		return limit
+	}
+	return MaxFileSize
+}
+
+// maxUploadSize 所有类型中最大的大小上限，识别出图片类型之前按它限制请求体大小
+func maxUploadSize() int64 {
+	limit := MaxFileSize
+	for _, n := range SizeLimits {
+		limit = max(limit, n)
+	}
+	return limit
+}
+
+// uploadName 返回保存上传文件时使用的文件名：generate 为 true 时返回空字符串，由 saveImage 生成文件名，
+// 否则 customName 清理后不为空时使用 customName，再否则使用上传时的文件名
+func uploadName(upload *multipart.FileHeader, customName string, generate bool) string {
+	if generate {
+		return ""
+	}
+	if sanitizeFilename(customName) != "" {
+		return customName
+	}
+	return upload.Filename
+}
+
+// generateNames 请求是否要求忽略上传时的文件名，通过 ?generate_name=true 或者请求头 X-Generate-Filename: true 指定
+func generateNames(context *gin.Context) bool {
+	return context.Query("generate_name") == "true" || context.GetHeader("X-Generate-Filename") == "true"
+}
+
+// uploadOptions 每次上传可以单独设置的选项
+type uploadOptions struct {
+	// clientIP 上传者的 IP
+	clientIP string
+	// userID 通过 JWT 登录的上传者，未登录时为 nil
+	userID *int64
+	// keepEXIF 不删除图片中的元数据
+	keepEXIF bool
+	// watermark 是否按配置添加水印
+	watermark bool
+}
+
+// requestUploadOptions 读取请求中的上传选项：?keep_exif=1 保留元数据，?nowatermark=1 不添加水印，
+// 也可以通过同名的表单字段设置。跳过水印需要请求中带有正确的 API_KEY
+func requestUploadOptions(context *gin.Context) uploadOptions {
+	return uploadOptions{
+		clientIP:  context.ClientIP(),
+		userID:    requestUserID(context),
+		keepEXIF:  requestFlag(context, "keep_exif"),
+		watermark: !requestFlag(context, "nowatermark") || !trustedUploader(context),
+	}
+}
+
+// requestFlag 查询参数或者 multipart 表单中的 name 是否为 1 或 true
+func requestFlag(context *gin.Context, name string) bool {
+	v := context.Query(name)
+	if v == "" && context.ContentType() == "multipart/form-data" {
+		v = context.PostForm(name)
+	}
+	return v == "1" || v == "true"
+}
+
+// trustedUploader 请求是否带有正确的 API_KEY，没有配置 API_KEY 时所有请求都不可信
+func trustedUploader(context *gin.Context) bool {
+	return APIKey != "" && validKey(requestAPIKey(context), APIKey)
+}
+
+// genericFilenames 浏览器粘贴剪贴板图片时使用的通用文件名（不含扩展名），每次粘贴都相同
+var genericFilenames = map[string]bool{
+	"image": true,
+	"blob":  true,
+}
+
+// isGenericFilename 文件名是否是 image.png、blob 这类通用名称
+func isGenericFilename(name string) bool {
+	return genericFilenames[strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))]
+}
+
+// generatedFilename 按当前时间生成扩展名为 ext 的文件名，例如 paste-20240501-153012-abc1.png
+func generatedFilename(ext string) (string, error) {
+	b := make([]byte, 2)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "paste-" + time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b) + "." + ext, nil
+}
+
+// errTooLarge 读取的内容超过了 countingReader 的 limit
+var errTooLarge = errors.New("file too large")
+
+// countingReader 记录已经读取的字节数，limit 大于 0 时超过 limit 返回 errTooLarge
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.limit > 0 && c.n > c.limit {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	ctx "context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+// tinyGIF 43 字节的 1x1 GIF
+var tinyGIF = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\xff\xff\xff\x00\x00\x00!\xf9\x04\x01\x00\x00\x00\x00" +
+	",\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// useTestStorage 把图片保存到临时目录，上传记录写入临时数据库，测试结束后恢复
+func useTestStorage(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	testDB, err := openDB(filepath.Join(root, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStorage, oldDB := Storage, db
+	Storage, db = NewLocalBackend(root, "http://localhost/static"), testDB
+	t.Cleanup(func() {
+		Storage, db = oldStorage, oldDB
+		_ = testDB.Close()
+	})
+	return root
+}
+
+// paddedPNG 返回一张在 IEND 之后补齐到 size 字节的 PNG
+func paddedPNG(t *testing.T, size int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > size {
+		t.Fatalf("encoded PNG is %d bytes, larger than %d", buf.Len(), size)
+	}
+	return append(buf.Bytes(), make([]byte, size-buf.Len())...)
+}
+
+func TestReadHead(t *testing.T) {
+	if len(tinyGIF) != 43 {
+		t.Fatalf("tinyGIF is %d bytes, want 43", len(tinyGIF))
+	}
+	file261 := paddedPNG(t, 261)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		size     int64
+		oneByte  bool
+		wantLen  int
+		wantType string
+	}{
+		{"tiny gif", tinyGIF, 43, false, 43, "gif"},
+		{"tiny gif unknown size", tinyGIF, -1, false, 43, "gif"},
+		{"tiny gif one byte at a time", tinyGIF, 43, true, 43, "gif"},
+		{"261 byte file", file261, 261, false, 261, "png"},
+		{"261 byte file one byte at a time", file261, -1, true, 261, "png"},
+		{"larger file one byte at a time", paddedPNG(t, 4096), 4096, true, 261, "png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := iotest.DataErrReader(bytes.NewReader(tt.data))
+			if tt.oneByte {
+				reader = iotest.OneByteReader(reader)
+			}
+			head, err := readHead(reader, tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(head) != tt.wantLen {
+				t.Fatalf("len(head) = %d, want %d", len(head), tt.wantLen)
+			}
+			if !bytes.Equal(head, tt.data[:tt.wantLen]) {
+				t.Fatal("head does not match the start of the file")
+			}
+			kind, ok := allowedType(head)
+			if !ok || kind.Extension != tt.wantType {
+				t.Errorf("allowedType = %q, %v, want %q", kind.Extension, ok, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSaveImageShortReads(t *testing.T) {
+	root := useTestStorage(t)
+
+	tests := []struct {
+		name string
+		file string
+		data []byte
+	}{
+		{"tiny gif", "tiny.gif", tinyGIF},
+		{"261 byte file", "exact.png", paddedPNG(t, 261)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := iotest.OneByteReader(bytes.NewReader(tt.data))
+			result, uploadErr := saveImage(ctx.Background(), tt.file, r, int64(len(tt.data)), uploadOptions{clientIP: "127.0.0.1"})
+			if uploadErr != nil {
+				t.Fatalf("saveImage: %d %s", uploadErr.Status, uploadErr.Message)
+			}
+			stored, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(result["path"].(string))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != len(tt.data) {
+				t.Errorf("stored %d bytes, want %d", len(stored), len(tt.data))
+			}
+		})
+	}
+}
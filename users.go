@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// usernamePattern 允许的用户名：3 到 32 位字母、数字、下划线或连字符
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+// minPasswordLength 密码的最小长度，bcrypt 只使用前 72 字节，更长的密码直接拒绝
+const minPasswordLength = 8
+
+// dummyPasswordHash 用户不存在时也比较一次密码，避免通过响应时间判断用户名是否已注册
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("go-drawing-bed"), bcrypt.DefaultCost)
+
+// userCredentials 注册和登录请求的 JSON 内容
+type userCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// registerHandler 注册用户，用户名不能重复，密码使用 bcrypt 哈希后保存
+func registerHandler(context *gin.Context) {
+	var req userCredentials
+	if err := context.ShouldBindJSON(&req); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误！"})
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "用户名只能包含 3 到 32 位字母、数字、下划线或连字符！"})
+		return
+	}
+	if len(req.Password) < minPasswordLength || len(req.Password) > 72 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "密码长度必须在 8 到 72 个字节之间！"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := createUser(req.Username, string(hash))
+	if errors.Is(err, errUserExists) {
+		context.JSON(http.StatusConflict, gin.H{"error": "用户名已存在！"})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	slog.InfoContext(context, "user registered", "user_id", id, "username", req.Username)
+	context.JSON(http.StatusCreated, gin.H{"message": "注册成功！", "user_id": id, "username": req.Username})
+}
+
+// loginHandler 校验用户名和密码，成功时返回 JWT，之后通过 Authorization: Bearer <token> 访问需要登录的接口
+func loginHandler(context *gin.Context) {
+	var req userCredentials
+	if err := context.ShouldBindJSON(&req); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误！"})
+		return
+	}
+
+	id, hash, err := findUser(req.Username)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if id == 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+	}
+	if id == 0 || bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		context.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误！"})
+		return
+	}
+
+	token, expires, err := signToken(id)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_at": expires.UTC().Format(time.RFC3339),
+		"user_id":    id,
+	})
+}
+
+// errUserExists 注册时用户名已经被使用
+var errUserExists = errors.New("user already exists")
+
+// createUser 保存新用户，返回用户 ID，用户名已存在时返回 errUserExists
+func createUser(username string, passwordHash string) (int64, error) {
+	result, err := db.Exec(`INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (username) DO NOTHING`, username, passwordHash, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, errUserExists
+	}
+	return result.LastInsertId()
+}
+
+// findUser 按用户名查找用户的 ID 和密码哈希，用户不存在时 ID 为 0
+func findUser(username string) (int64, string, error) {
+	var id int64
+	var hash string
+	err := db.QueryRow(`SELECT id, password_hash FROM users WHERE username = ?`, username).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", nil
+	}
+	return id, hash, err
+}
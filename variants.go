@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/disintegration/imaging"
+	"github.com/gin-gonic/gin"
+	"image"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxVariantSize 按参数缩放图片时允许的最大宽高
+const maxVariantSize = 8192
+
+// 缩放方式
+const (
+	// FitContain 等比缩小到不超过指定的宽高
+	FitContain = "contain"
+	// FitCover 等比缩放并居中裁剪到指定的宽高
+	FitCover = "cover"
+)
+
+// variantSources 可以缩放或转换格式的原图格式
+var variantSources = map[string]bool{
+	"jpg":  true,
+	"png":  true,
+	"bmp":  true,
+	"webp": true,
+	"avif": true,
+}
+
+// variantFormats 可以通过 ?format= 指定的输出格式
+var variantFormats = map[string]bool{
+	"jpg":  true,
+	"png":  true,
+	"webp": true,
+	"avif": true,
+}
+
+// variantOptions 访问图片时通过 ?w=&h=&fit=&format= 指定的参数，宽高为 0 表示不限制，
+// format 为空时使用原图的格式。crop、aspect 和 gravity 是 /crop 的裁剪参数，在缩放之前进行
+type variantOptions struct {
+	width   int
+	height  int
+	fit     string
+	format  string
+	crop    image.Rectangle
+	aspect  image.Point
+	gravity string
+}
+
+// wantsVariant 请求是否带有缩放或者转换格式的参数
+func wantsVariant(context *gin.Context) bool {
+	return context.Query("w") != "" || context.Query("h") != "" || context.Query("format") != ""
+}
+
+// parseVariantOptions 读取缩放参数，宽高必须在 1 到 maxVariantSize 之间
+func parseVariantOptions(context *gin.Context) (*variantOptions, error) {
+	opts := &variantOptions{fit: context.DefaultQuery("fit", FitContain)}
+	for _, p := range []struct {
+		key   string
+		value *int
+	}{{"w", &opts.width}, {"h", &opts.height}} {
+		v := context.Query(p.key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxVariantSize {
+			return nil, fmt.Errorf("%s 必须在 1 到 %d 之间！", p.key, maxVariantSize)
+		}
+		*p.value = n
+	}
+	if opts.fit != FitContain && opts.fit != FitCover {
+		return nil, fmt.Errorf("fit 只能是 %s 或 %s！", FitContain, FitCover)
+	}
+	if v := context.Query("format"); v != "" {
+		opts.format = normalizeType(v)
+		if !variantFormats[opts.format] {
+			return nil, errors.New("format 只能是 jpg、png、webp 或 avif！")
+		}
+	}
+	if opts.fit == FitCover && (opts.width == 0 || opts.height == 0) {
+		// 只指定一边时裁剪没有意义
+		opts.fit = FitContain
+	}
+	return opts, nil
+}
+
+// serveVariant 返回 ./static 下图片 name 按 ?w=&h=&fit=&format= 缩放、转换格式后的版本。
+// 结果按路径、参数以及原图的大小和修改时间缓存在 ResizeCacheDir，原图删除或替换时删除缓存，
+// 缓存总大小超过 ResizeCacheMaxSize 时淘汰最久没有访问的版本。
+// 只会缩小不会放大，不支持的格式返回 400，AVIF 编码超时时返回原图格式的版本
+func serveVariant(context *gin.Context, name string) {
+	opts, err := parseVariantOptions(context)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ext := normalizeType(strings.TrimPrefix(path.Ext(name), "."))
+	if !variantSources[ext] {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "该文件不支持缩放！"})
+		return
+	}
+	sendVariant(context, name, ext, opts)
+}
+
+// sendVariant 生成或者从缓存中读取 ./static 下 ext 格式的图片 name 按 opts 处理后的版本并返回，
+// 路径不在 ./static 内时返回 400，原图像素数超过 MaxPixels 时不解码并返回 422
+func sendVariant(context *gin.Context, name string, ext string, opts *variantOptions) {
+	if opts.format == "" {
+		opts.format = ext
+	}
+
+	src, err := (&LocalBackend{Root: staticDir}).resolve(name)
+	if errors.Is(err, errOutsideRoot) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "文件路径无效！"})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return
+	}
+	stat, err := os.Stat(src)
+	if err != nil || stat.IsDir() {
+		context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return
+	}
+
+	cached, err := cachedVariant(src, name, stat, opts)
+	if errors.Is(err, errEncodeTimeout) && opts.format != ext {
+		slog.WarnContext(context, "image conversion timed out, serving the original format", "path", name, "format", opts.format)
+		opts.format = ext
+		cached, err = cachedVariant(src, name, stat, opts)
+	}
+	if errors.Is(err, errCropOutOfBounds) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errTooManyPixels) {
+		context.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "图片解码失败！"})
+		return
+	}
+	context.File(cached)
+}
+
+// variantSemaphore 缩放、裁剪和转换格式共用，限制同时解码的图片数量，第一次使用时按 ResizeConcurrency 创建
+var variantSemaphore = sync.OnceValue(func() chan struct{} {
+	return make(chan struct{}, ResizeConcurrency)
+})
+
+// variantLocks 同一个版本同时被请求时只生成一次
+var variantLocks = newKeyedMutex()
+
+// cachedVariant 返回缓存中 src 按 opts 处理后的文件路径，没有缓存时生成
+func cachedVariant(src string, name string, stat os.FileInfo, opts *variantOptions) (string, error) {
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%d|%s|%s|%v|%v|%s", name, stat.Size(), stat.ModTime().UnixNano(),
+		opts.width, opts.height, opts.fit, opts.format, opts.crop, opts.aspect, opts.gravity)))
+	cached := filepath.Join(variantDir(name), hex.EncodeToString(key[:])+"."+opts.format)
+	if _, err := os.Stat(cached); err == nil {
+		touchVariant(cached)
+		return cached, nil
+	}
+
+	unlock := variantLocks.Lock(cached)
+	defer unlock()
+	// 等待期间其他请求可能已经生成了同一个版本
+	if _, err := os.Stat(cached); err == nil {
+		touchVariant(cached)
+		return cached, nil
+	}
+	semaphore := variantSemaphore()
+	semaphore <- struct{}{}
+	defer func() { <-semaphore }()
+
+	err := createVariant(src, cached, opts)
+	if err != nil {
+		return cached, err
+	}
+	if stat, err := os.Stat(cached); err == nil {
+		addVariant(stat.Size())
+	}
+	return cached, nil
+}
+
+// createVariant 缩放 src 并按 opts.format 保存到 dst，先写入临时文件再重命名，避免同时请求时读到写了一半的文件
+func createVariant(src string, dst string, opts *variantOptions) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, _, err := decodeImage(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	img, err = cropImage(img, opts)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	var resized image.Image
+	if opts.fit == FitCover {
+		// 按比例缩小目标尺寸，使其不超过原图，保持请求的宽高比
+		scale := math.Min(1, math.Min(float64(bounds.Dx())/float64(opts.width), float64(bounds.Dy())/float64(opts.height)))
+		width := max(1, int(math.Round(float64(opts.width)*scale)))
+		height := max(1, int(math.Round(float64(opts.height)*scale)))
+		resized = imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+	} else {
+		// 没有指定宽高时只转换格式
+		width, height := opts.width, opts.height
+		if width == 0 {
+			width = bounds.Dx()
+		}
+		if height == 0 {
+			height = bounds.Dy()
+		}
+		resized = imaging.Fit(img, width, height, imaging.Lanczos)
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0750)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".variant-*")
+	if err != nil {
+		return err
+	}
+	err = encodeImage(tmp, resized, opts.format)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCropAndResizeShareDecodeLimit(t *testing.T) {
+	root := t.TempDir()
+	old := ResizeCacheDir
+	ResizeCacheDir = filepath.Join(root, "cache")
+	t.Cleanup(func() { ResizeCacheDir = old })
+
+	src := filepath.Join(root, "photo.png")
+	if err := os.WriteFile(src, paddedPNG(t, 512), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 占满所有名额后，缩放和裁剪都要排队等待
+	semaphore := variantSemaphore()
+	for range cap(semaphore) {
+		semaphore <- struct{}{}
+	}
+	requests := map[string]*variantOptions{
+		"resize": {width: 1, height: 1, fit: FitContain, format: "png"},
+		"crop":   {fit: FitContain, format: "png", crop: image.Rect(0, 0, 1, 1)},
+	}
+	done := make(chan string, len(requests))
+	for kind, opts := range requests {
+		go func() {
+			if _, err := cachedVariant(src, "photo.png", stat, opts); err != nil {
+				t.Errorf("%s: %v", kind, err)
+			}
+			done <- kind
+		}()
+	}
+	select {
+	case kind := <-done:
+		t.Fatalf("%s finished while every decode slot was taken", kind)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for range cap(semaphore) {
+		<-semaphore
+	}
+	for range requests {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("variant not created after the decode slots were released")
+		}
+	}
+}
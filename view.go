@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// viewTemplate /view 页面的模板，与其它页面一样嵌入在程序中
+var viewTemplate = template.Must(template.ParseFS(htmlFS, "html/view.html"))
+
+// viewPage 渲染 /view 页面使用的数据
+type viewPage struct {
+	Title       string
+	Description string
+	PageURL     string
+	ImageURL    string
+	MimeType    string
+	Width       int
+	Height      int
+}
+
+// viewHandler 返回 /view/:year/:month/:day/:filename 对应图片的预览页面，页面中带有 Open Graph 标签，
+// 在聊天软件和社交网站中分享时会显示图片、宽高和大小。与 /static 一样开启 REQUIRE_SIGNED_URLS 时需要签名
+func viewHandler(context *gin.Context) {
+	fileName := context.Param("filename")
+	if fileName == "." || fileName == ".." || strings.ContainsAny(fileName, "/\\") {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "文件名无效！"})
+		return
+	}
+	dir := make([]string, 0, 3)
+	for _, key := range []string{"year", "month", "day"} {
+		n, err := strconv.Atoi(context.Param(key))
+		if err != nil || n <= 0 {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "日期无效！"})
+			return
+		}
+		dir = append(dir, strconv.Itoa(n))
+	}
+	name := strings.Join(dir, "/") + "/" + fileName
+	if !checkImageAccess(context, name) {
+		return
+	}
+
+	page, err := imageViewPage(name)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if page == nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "图片不存在！"})
+		return
+	}
+	page.PageURL = Url + context.Request.URL.Path
+	// 签名地址的参数同样用于图片地址
+	if context.Query("token") != "" {
+		page.ImageURL += "?" + context.Request.URL.RawQuery
+		page.PageURL += "?" + context.Request.URL.RawQuery
+	}
+
+	context.Header("Content-Type", "text/html; charset=utf-8")
+	if err := viewTemplate.Execute(context.Writer, page); err != nil {
+		slog.ErrorContext(context, "failed to render view page", "path", name, "error", err)
+	}
+}
+
+// imageViewPage 从上传记录中读取图片 name 的信息，没有记录的本地图片读取文件头，图片不存在时返回 nil
+func imageViewPage(name string) (*viewPage, error) {
+	page := &viewPage{Title: path.Base(name), ImageURL: Storage.URL(name)}
+	record, err := findImage(name)
+	if err != nil {
+		return nil, err
+	}
+	var width, height *int
+	var size int64
+	if record != nil {
+		if record.OriginalFilename != "" {
+			page.Title = record.OriginalFilename
+		}
+		page.MimeType = record.MimeType
+		width, height, size = record.Width, record.Height, record.SizeBytes
+	} else {
+		// 上传记录功能之前上传的图片
+		src, err := (&LocalBackend{Root: staticDir}).resolve(name)
+		if err != nil {
+			return nil, nil
+		}
+		stat, err := os.Stat(src)
+		if err != nil || stat.IsDir() {
+			return nil, nil
+		}
+		size = stat.Size()
+		page.MimeType = mimeType(normalizeType(strings.TrimPrefix(path.Ext(name), ".")))
+		if file, err := os.Open(src); err == nil {
+			width, height, _ = imageDimensions(func() (io.Reader, error) {
+				return file, nil
+			})
+			_ = file.Close()
+		}
+	}
+
+	page.Description = readableSize(size)
+	if width != nil && height != nil {
+		page.Width, page.Height = *width, *height
+		page.Description = strconv.Itoa(*width) + "×" + strconv.Itoa(*height) + " · " + page.Description
+	}
+	return page, nil
+}
+
+// readableSize 把字节数格式化为 1.5MB、320.0KB 这样保留一位小数的大小
+func readableSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.size && u.size > 1 {
+			return strconv.FormatFloat(float64(n)/float64(u.size), 'f', 1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
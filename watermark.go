@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"os"
+	"sync"
+)
+
+// watermarkFormats 支持添加水印的图片格式，GIF、SVG 原样保存
+var watermarkFormats = map[string]bool{
+	"jpg":  true,
+	"png":  true,
+	"bmp":  true,
+	"webp": true,
+}
+
+// 水印位置
+const (
+	WatermarkTopLeft     = "top-left"
+	WatermarkTopRight    = "top-right"
+	WatermarkBottomLeft  = "bottom-left"
+	WatermarkBottomRight = "bottom-right"
+	WatermarkCenter      = "center"
+)
+
+// watermarkPositions WATERMARK_POSITION 可以使用的值
+var watermarkPositions = map[string]bool{
+	WatermarkTopLeft:     true,
+	WatermarkTopRight:    true,
+	WatermarkBottomLeft:  true,
+	WatermarkBottomRight: true,
+	WatermarkCenter:      true,
+}
+
+// watermarkFont 水印使用的字体，第一次使用时解析
+var watermarkFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// watermarkImage WATERMARK_FILE 中的水印图片，启动时加载，没有配置时为 nil
+var watermarkImage image.Image
+
+// loadWatermarkImage 读取水印图片，建议使用带透明通道的 PNG
+func loadWatermarkImage(name string) (image.Image, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := decodeImage(file)
+	return img, err
+}
+
+// needsWatermark 是否需要给 ext 格式的图片添加水印，watermark 为 false 表示本次上传不添加
+func needsWatermark(ext string, watermark bool) bool {
+	return watermark && (watermarkImage != nil || WatermarkText != "") && watermarkFormats[ext]
+}
+
+// drawWatermark 在图片的 WatermarkPosition 位置添加水印，配置了 WATERMARK_FILE 时使用图片水印，否则使用 WatermarkText。
+// 图片比水印图片（加上边距）还小时不添加，返回的 bool 表示是否添加了水印
+func drawWatermark(img image.Image) (image.Image, bool, error) {
+	if watermarkImage != nil {
+		return drawImageWatermark(img)
+	}
+	return drawTextWatermark(img)
+}
+
+// drawImageWatermark 按 WatermarkOpacity 把 watermarkImage 叠加到图片上，边距默认为 16 像素
+func drawImageWatermark(img image.Image) (image.Image, bool, error) {
+	margin := WatermarkMargin
+	if margin < 0 {
+		margin = 16
+	}
+	bounds, mark := img.Bounds(), watermarkImage.Bounds()
+	if bounds.Dx() < mark.Dx()+2*margin || bounds.Dy() < mark.Dy()+2*margin {
+		return img, false, nil
+	}
+
+	at := watermarkPoint(bounds.Dx(), bounds.Dy(), mark.Dx(), mark.Dy(), margin)
+	return imaging.Overlay(img, watermarkImage, at, WatermarkOpacity), true, nil
+}
+
+// drawTextWatermark 绘制 WatermarkText，字号约为短边的 2%，边距默认与字号相同
+func drawTextWatermark(img image.Image) (image.Image, bool, error) {
+	f, err := watermarkFont()
+	if err != nil {
+		return nil, false, err
+	}
+
+	bounds := img.Bounds()
+	size := float64(min(bounds.Dx(), bounds.Dy())) * 0.02
+	size = max(size, 8)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, false, err
+	}
+	defer face.Close()
+
+	canvas := imaging.Clone(img)
+	alpha := uint8(WatermarkOpacity * 255)
+	drawer := &font.Drawer{Dst: canvas, Face: face}
+
+	margin := WatermarkMargin
+	if margin < 0 {
+		margin = int(size)
+	}
+	metrics := face.Metrics()
+	width := drawer.MeasureString(WatermarkText).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	at := watermarkPoint(canvas.Bounds().Dx(), canvas.Bounds().Dy(), width, height, margin)
+	x, y := fixed.I(at.X), fixed.I(at.Y)+metrics.Ascent
+
+	// 先绘制一层偏移的深色阴影，保证浅色背景上也能看清
+	shadow := max(fixed.I(1), fixed.I(int(size))/8)
+	drawer.Src = image.NewUniform(color.NRGBA{A: alpha / 2})
+	drawer.Dot = fixed.Point26_6{X: x + shadow, Y: y + shadow}
+	drawer.DrawString(WatermarkText)
+
+	drawer.Src = image.NewUniform(color.NRGBA{R: 255, G: 255, B: 255, A: alpha})
+	drawer.Dot = fixed.Point26_6{X: x, Y: y}
+	drawer.DrawString(WatermarkText)
+
+	return canvas, true, nil
+}
+
+// watermarkPoint 按 WatermarkPosition 计算宽高为 width、height 的水印在 canvasWidth x canvasHeight 的图片中左上角的位置
+func watermarkPoint(canvasWidth int, canvasHeight int, width int, height int, margin int) image.Point {
+	switch WatermarkPosition {
+	case WatermarkTopLeft:
+		return image.Pt(margin, margin)
+	case WatermarkTopRight:
+		return image.Pt(canvasWidth-width-margin, margin)
+	case WatermarkBottomLeft:
+		return image.Pt(margin, canvasHeight-height-margin)
+	case WatermarkCenter:
+		return image.Pt((canvasWidth-width)/2, (canvasHeight-height)/2)
+	default:
+		return image.Pt(canvasWidth-width-margin, canvasHeight-height-margin)
+	}
+}
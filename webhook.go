@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout 每次发送 webhook 请求的超时时间
+const webhookTimeout = 10 * time.Second
+
+// webhookRetries 发送失败后最多重试的次数，每次重试前等待的时间翻倍
+const webhookRetries = 3
+
+// webhookClient 发送 webhook 使用的客户端
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookEvent 上传成功后发送给 WEBHOOK_URL 的 JSON 内容
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Size      int64     `json:"size"`
+	MimeType  string    `json:"mime_type"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notifyUpload 配置了 WEBHOOK_URL 时在后台发送上传成功的通知，不等待发送完成
+func notifyUpload(name string, url string, size int64, mimeType string) {
+	if WebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(webhookEvent{
+		Event:     "upload",
+		Name:      name,
+		URL:       url,
+		Size:      size,
+		MimeType:  mimeType,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		slog.Error("failed to encode webhook payload", "error", err)
+		return
+	}
+	go sendWebhook(body)
+}
+
+// sendWebhook 把 body POST 到 WEBHOOK_URL，网络错误、429 和 5xx 时重试，其它状态码不重试
+func sendWebhook(body []byte) {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= webhookRetries {
+			slog.Warn("failed to send webhook", "url", WebhookURL, "attempts", attempt+1, "error", err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook 发送一次 webhook 请求，配置了 WEBHOOK_SECRET 时在 X-Signature-256 请求头中带上
+// sha256= 加上请求体的 HMAC-SHA256 签名。返回的 retry 表示失败是否是暂时的
+func postWebhook(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(WebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
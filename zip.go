@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	ctx "context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"mime/multipart"
+	"net/http"
+)
+
+// zipHandler 上传 ZIP 压缩包，逐个保存其中的图片，返回每个条目的处理结果。
+// 目录和不允许上传的文件会被跳过，单个图片保存失败不影响其它图片
+func zipHandler(context *gin.Context) {
+	if context.Request.ContentLength > ZipMaxSize {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "压缩包不能超过 " + formatSize(ZipMaxSize) + "！"})
+		return
+	}
+	// 预留 1MB 给 multipart 的其它部分
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, ZipMaxSize+1<<20)
+
+	upload, err := context.FormFile("file")
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "请选择要上传的 ZIP 压缩包！"})
+		return
+	}
+	if upload.Size > ZipMaxSize {
+		context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "压缩包不能超过 " + formatSize(ZipMaxSize) + "！"})
+		return
+	}
+
+	file, err := upload.Open()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer func(file multipart.File) {
+		_ = file.Close()
+	}(file)
+
+	archive, err := zip.NewReader(file, upload.Size)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "无法读取 ZIP 压缩包！"})
+		return
+	}
+	if len(archive.File) > ZipMaxEntries {
+		context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("压缩包中的文件不能超过 %d 个！", ZipMaxEntries)})
+		return
+	}
+
+	results := make([]gin.H, 0, len(archive.File))
+	succeeded, skipped := 0, 0
+	// 按条目声明的大小累计解压后的总大小，archive/zip 会在实际内容超过声明的大小时返回错误
+	var total uint64
+	opts := requestUploadOptions(context)
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		total += entry.UncompressedSize64
+		if total > uint64(ZipMaxTotalSize) {
+			skipped++
+			results = append(results, gin.H{"name": entry.Name, "skipped": true, "error": "解压后的总大小超过 " + formatSize(ZipMaxTotalSize) + "！"})
+			continue
+		}
+		if entry.UncompressedSize64 > uint64(maxUploadSize()) {
+			results = append(results, gin.H{"name": entry.Name, "error": fileTooLargeMessage()})
+			continue
+		}
+
+		data, uploadErr, allowed := saveZipEntry(context, entry, opts)
+		switch {
+		case !allowed:
+			skipped++
+			results = append(results, gin.H{"name": entry.Name, "skipped": true, "error": "文件类型不允许上传，已跳过！"})
+		case uploadErr != nil:
+			results = append(results, gin.H{"name": entry.Name, "error": uploadErr.Message})
+		default:
+			succeeded++
+			data["entry"] = entry.Name
+			results = append(results, data)
+		}
+	}
+
+	status := http.StatusOK
+	if succeeded == 0 && len(results) > skipped {
+		status = http.StatusBadRequest
+	}
+	context.JSON(status,
+		gin.H{
+			"message": fmt.Sprintf("共 %d 个文件，上传成功 %d 张，跳过 %d 个！", len(results), succeeded, skipped),
+			"data":    results,
+		},
+	)
+}
+
+// saveZipEntry 保存压缩包中的一个文件，文件类型不允许上传时 allowed 为 false
+func saveZipEntry(c ctx.Context, entry *zip.File, opts uploadOptions) (data gin.H, uploadErr *uploadError, allowed bool) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "无法解压：" + err.Error()}, true
+	}
+	defer rc.Close()
+
+	// 先根据文件头判断是否允许上传，不允许的文件跳过而不是作为错误返回
+	r := bufio.NewReader(rc)
+	head, _ := r.Peek(261)
+	if _, ok := allowedType(head); !ok {
+		return nil, nil, false
+	}
+
+	data, uploadErr = saveImage(c, entry.Name, r, int64(entry.UncompressedSize64), opts)
+	return data, uploadErr, true
+}